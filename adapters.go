@@ -0,0 +1,72 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// chatAdapter is a chatAgent that Mario can run on
+// Adapters must be added to the adapters map so they can be selected with --adapter
+type chatAdapter interface {
+	chatAgent
+	// connect opens the connection to the chat service
+	connect() error
+	// command returns the message text without Mario's mention
+	// and true if the message is addressed to Mario
+	command(msg Message) (string, bool)
+}
+
+// register new adapters here
+// by mapping the --adapter name to a function that creates the adapters.
+// Mario runs one connection per adapter, e.g. one per Slack workspace
+var adapters = map[string]func() ([]chatAdapter, error){}
+
+func init() {
+	adapters["slack"] = newSlackAdapters
+	adapters["shell"] = single(newShellAdapter)
+	adapters["irc"] = single(newIRCAdapter)
+	adapters["mattermost"] = single(newMattermostAdapter)
+	adapters["replay"] = single(newReplayAdapter)
+}
+
+// single wraps the constructor of an adapter that only opens one connection
+func single(newAdapter func() (chatAdapter, error)) func() ([]chatAdapter, error) {
+	return func() ([]chatAdapter, error) {
+		chat, err := newAdapter()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return []chatAdapter{chat}, nil
+	}
+}
+
+// adapterNames lists the names of the registered adapters
+// Returns a comma separated string
+func adapterNames() string {
+	var names []string
+	for name := range adapters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// workspaceOf returns the ID of the workspace an adapter is connected to
+// only Slack has workspaces, other adapters return an empty string
+func workspaceOf(chat chatAgent) string {
+	if slack, ok := chat.(*Slack); ok {
+		return slack.teamID()
+	}
+	return ""
+}
+
+// agents returns the chat connections as chatAgents
+func agents(chats []chatAdapter) []chatAgent {
+	list := make([]chatAgent, len(chats))
+	for i, chat := range chats {
+		list[i] = chat
+	}
+	return list
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// adminAPI lets other services post messages and inspect Mario over HTTP,
+// requests must send ADMIN_API_TOKEN as a bearer token
+type adminAPI struct {
+	chats   []chatAgent
+	adapter string
+	routes  map[string]adminRoute
+}
+
+// adminRoute is an endpoint of the admin API
+type adminRoute struct {
+	method string
+	handle func(w http.ResponseWriter, r *http.Request)
+}
+
+// channelDirectory is implemented by the adapters that can find a channel by its name
+type channelDirectory interface {
+	channelID(name string) (string, bool)
+}
+
+// helpRecorder is a chat agent that keeps the messages the tasks post
+type helpRecorder struct {
+	texts []string
+}
+
+func (h *helpRecorder) getMessage() (Message, error) {
+	return Message{}, io.EOF
+}
+
+func (h *helpRecorder) postMessage(msg Message) error {
+	h.texts = append(h.texts, msg.Text)
+	return nil
+}
+
+// taskHelp returns the help a task posts
+func taskHelp(task Task) string {
+	recorder := &helpRecorder{}
+
+	if err := task.Help(recorder, Message{Type: "message"}); err != nil {
+		logs.error("cannot get the help", "task", task.getName(), "err", err)
+	}
+
+	return strings.Join(recorder.texts, "\n")
+}
+
+// the largest request body the admin API reads
+const maxAdminRequestSize = 64 << 10
+
+// when Mario started
+var startedAt = time.Now()
+
+// newAdminAPI creates the admin API of the chat connections
+func newAdminAPI(chats []chatAgent, adapter string) *adminAPI {
+	api := &adminAPI{chats: chats, adapter: adapter}
+
+	api.routes = map[string]adminRoute{
+		"messages":      {"POST", api.postMessage},
+		"tasks":         {"GET", api.listTasks},
+		"status":        {"GET", api.status},
+		"conversations": {"GET", api.listConversations},
+		"jobs":          {"GET", api.listJobs},
+		"reload":        {"POST", api.reload},
+		"audit":         {"GET", api.exportAudit},
+	}
+
+	return api
+}
+
+// handleAdminAPI serves the admin API on /admin/api/
+func handleAdminAPI(chats []chatAdapter, adapter string) {
+	httpMux.Handle("/admin/api/", newAdminAPI(agents(chats), adapter))
+}
+
+// ServeHTTP authenticates a request and passes it to its endpoint
+func (a *adminAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := workspaceSetting("", "ADMIN_API_TOKEN")
+
+	// the token is read on every request, a reload can enable the API or rotate the token
+	if token == "" {
+		writeJSON(w, http.StatusNotFound, adminError(fmt.Sprintf("The admin API is disabled, set %s to enable it", settingName("ADMIN_API_TOKEN"))))
+		return
+	}
+
+	if !authorizedBearer(r, token) {
+		logs.warn("refused an admin API request", "path", r.URL.Path, "remote", r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="mario"`)
+		writeJSON(w, http.StatusUnauthorized, adminError("Invalid token"))
+		return
+	}
+
+	route, ok := a.routes[strings.TrimPrefix(r.URL.Path, "/admin/api/")]
+
+	if !ok {
+		writeJSON(w, http.StatusNotFound, adminError("Unknown endpoint "+r.URL.Path))
+		return
+	}
+
+	if r.Method != route.method {
+		w.Header().Set("Allow", route.method)
+		writeJSON(w, http.StatusMethodNotAllowed, adminError("Only "+route.method+" is allowed"))
+		return
+	}
+
+	route.handle(w, r)
+}
+
+// authorizedBearer checks the bearer token of a request
+func authorizedBearer(r *http.Request, token string) bool {
+	auth := r.Header.Get("Authorization")
+
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) == 1
+}
+
+// adminError is the body of a failed request
+func adminError(message string) map[string]string {
+	return map[string]string{"error": message}
+}
+
+// writeJSON answers a request with a JSON body
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+// chat finds the connection of a workspace,
+// the workspace can be left out when Mario has a single connection
+func (a *adminAPI) chat(workspace string) (chatAgent, error) {
+	var workspaces []string
+
+	for _, chat := range a.chats {
+		if workspace != "" && workspaceOf(chat) == workspace {
+			return chat, nil
+		}
+		workspaces = append(workspaces, workspaceOf(chat))
+	}
+
+	if workspace == "" && len(a.chats) == 1 {
+		return a.chats[0], nil
+	}
+
+	if workspace == "" {
+		return nil, fmt.Errorf("Set the workspace, Mario is connected to %s", strings.Join(workspaces, ", "))
+	}
+
+	return nil, fmt.Errorf("Mario isn't connected to the workspace %s", workspace)
+}
+
+// resolveChannel returns the ID of a channel given by its name or ID
+func resolveChannel(chat chatAgent, channel string) (string, error) {
+	directory, ok := chat.(channelDirectory)
+
+	if !ok {
+		return channel, nil
+	}
+
+	if id, ok := directory.channelID(channel); ok {
+		return id, nil
+	}
+
+	if strings.HasPrefix(channel, "#") {
+		return "", fmt.Errorf("Unknown channel %s", channel)
+	}
+
+	return channel, nil
+}
+
+// postMessage posts a message in a channel
+// POST /admin/api/messages {"channel": "#general", "text": "...", "workspace": "T024BE7LD", "thread_ts": "..."}
+func (a *adminAPI) postMessage(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Channel   string `json:"channel"`
+		Text      string `json:"text"`
+		Workspace string `json:"workspace"`
+		ThreadTs  string `json:"thread_ts"`
+	}
+
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminRequestSize)).Decode(&request); err != nil {
+		writeJSON(w, http.StatusBadRequest, adminError("Invalid JSON: "+err.Error()))
+		return
+	}
+
+	if request.Channel == "" || strings.TrimSpace(request.Text) == "" {
+		writeJSON(w, http.StatusBadRequest, adminError("Both channel and text are required"))
+		return
+	}
+
+	if containsSecret(request.Text) {
+		writeJSON(w, http.StatusUnprocessableEntity, adminError("The text looks like it contains a secret"))
+		return
+	}
+
+	chat, err := a.chat(request.Workspace)
+
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, adminError(err.Error()))
+		return
+	}
+
+	id, err := resolveChannel(chat, request.Channel)
+
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, adminError(err.Error()))
+		return
+	}
+
+	msg := Message{
+		Type:          "message",
+		Channel:       id,
+		Text:          request.Text,
+		Workspace:     workspaceOf(chat),
+		ThreadTs:      request.ThreadTs,
+		CorrelationID: newCorrelationID(),
+	}
+
+	logs.info("posting a message of the admin API", "id", msg.CorrelationID, "workspace", msg.Workspace, "channel", id, "remote", r.RemoteAddr)
+
+	if err := chat.postMessage(msg); err != nil {
+		logs.error("cannot post a message of the admin API", "id", msg.CorrelationID, "err", err)
+		writeJSON(w, http.StatusBadGateway, adminError(redactError(err).Error()))
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]string{"id": msg.CorrelationID, "workspace": msg.Workspace, "channel": id})
+}
+
+// listTasks lists the tasks and their help
+// GET /admin/api/tasks
+func (a *adminAPI) listTasks(w http.ResponseWriter, r *http.Request) {
+	type taskInfo struct {
+		Name string `json:"name"`
+		Help string `json:"help"`
+	}
+
+	list := []taskInfo{}
+
+	for _, task := range tasks {
+		list = append(list, taskInfo{Name: task.getName(), Help: taskHelp(task)})
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+// status shows the health of the connections
+// GET /admin/api/status
+func (a *adminAPI) status(w http.ResponseWriter, r *http.Request) {
+	ready, components := readiness(time.Now())
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"adapter":    a.adapter,
+		"ready":      ready,
+		"started":    startedAt,
+		"uptime":     time.Since(startedAt).Truncate(time.Second).String(),
+		"config":     config().path,
+		"components": components,
+	})
+}
+
+// listConversations lists the questions waiting for an answer
+// GET /admin/api/conversations
+func (a *adminAPI) listConversations(w http.ResponseWriter, r *http.Request) {
+	list := conversations.list()
+
+	if list == nil {
+		list = []conversation{}
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+// listJobs lists the scheduled jobs
+// GET /admin/api/jobs
+func (a *adminAPI) listJobs(w http.ResponseWriter, r *http.Request) {
+	type jobInfo struct {
+		Name      string    `json:"name"`
+		Interval  string    `json:"interval"`
+		LastRun   time.Time `json:"last_run"`
+		NextRun   time.Time `json:"next_run"`
+		LastError string    `json:"last_error,omitempty"`
+	}
+
+	list := []jobInfo{}
+
+	for _, job := range jobs.list() {
+		list = append(list, jobInfo{job.Name, job.Interval.String(), job.LastRun, job.NextRun, redact(job.LastError)})
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+// reload reloads the configuration, like SIGHUP
+// POST /admin/api/reload
+func (a *adminAPI) reload(w http.ResponseWriter, r *http.Request) {
+	reload, err := reloadAndReport(a.chats, a.adapter)
+
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, adminError(redactError(err).Error()))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"path":    reload.path,
+		"changed": reload.changed,
+		"restart": reload.restart,
+		"summary": reload.String(),
+	})
+}
+
+// exportAudit writes the audit records in JSON lines, the oldest first
+// GET /admin/api/audit?workspace=T024BE7LD&user=U024BE7LH&task=deploy&since=7d
+func (a *adminAPI) exportAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := auditFilter{Workspace: query.Get("workspace"), User: query.Get("user"), Task: query.Get("task")}
+
+	if value := query.Get("since"); value != "" {
+		since, ok := parseSince(value, time.Now())
+
+		if !ok {
+			writeJSON(w, http.StatusBadRequest, adminError("since must be a duration such as 2h or 7d, or a day such as 2016-01-02"))
+			return
+		}
+
+		filter.Since = since
+	}
+
+	records, err := audit.query(filter)
+
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, adminError(redactError(err).Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+
+	for _, record := range records {
+		encoder.Encode(record)
+	}
+}
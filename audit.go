@@ -0,0 +1,484 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/umbrellium/mario/brain"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Audit struct
+// tells the admins who asked Mario to do what
+type Audit struct {
+}
+
+// auditRecord is a command Mario received and what became of it
+type auditRecord struct {
+	ID        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	Workspace string    `json:"workspace,omitempty"`
+	Channel   string    `json:"channel"`
+	User      string    `json:"user"`
+	// Text is the command without Mario's mention, secrets are redacted
+	Text string   `json:"text"`
+	Task string   `json:"task,omitempty"`
+	Args []string `json:"args,omitempty"`
+	// Outcome is handled, answered or unknown,
+	// or what the task reported, such as deployed or failed
+	Outcome  string        `json:"outcome"`
+	Duration time.Duration `json:"duration_ns"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// auditFilter selects audit records, empty fields match every record
+type auditFilter struct {
+	Workspace string
+	User      string
+	Task      string
+	Since     time.Time
+}
+
+func (f auditFilter) match(r auditRecord) bool {
+	return (f.Workspace == "" || r.Workspace == f.Workspace) &&
+		(f.User == "" || r.User == f.User) &&
+		(f.Task == "" || r.Task == f.Task) &&
+		!r.Time.Before(f.Since)
+}
+
+// auditOutcome is how a command ended, as its task reported it
+type auditOutcome struct {
+	outcome string
+	err     string
+}
+
+// auditLog records the commands in the brain,
+// each record expires after AUDIT_RETENTION
+type auditLog struct {
+	// mu only guards reported, the brain is read and written without it
+	mu sync.Mutex
+	// outcomes reported before their command was recorded, by correlation ID
+	reported map[string]auditOutcome
+}
+
+// the commands Mario received
+var audit = &auditLog{reported: map[string]auditOutcome{}}
+
+// the default AUDIT_RETENTION
+const defaultAuditRetention = 90 * 24 * time.Hour
+
+// how many records `@mario audit` posts, the export has them all
+const auditChatLimit = 20
+
+// store returns the part of the brain where the records are kept
+func (a *auditLog) store() brain.Store {
+	return taskBrain(Audit{})
+}
+
+// auditKey sorts the records by day and time, e.g. 2016-01-02/15:04:05.000000000/4f2a9c1e0b7d3a65
+func auditKey(r auditRecord) string {
+	return auditDay(r.Time) + r.Time.UTC().Format("15:04:05.000000000") + "/" + r.ID
+}
+
+// auditDay is the prefix of the records of a day, e.g. 2016-01-02/
+func auditDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02") + "/"
+}
+
+// auditIDKey keeps the key of the record of a command, e.g. id/4f2a9c1e0b7d3a65
+func auditIDKey(id string) string {
+	return "id/" + id
+}
+
+// parseAge reads a duration that can be given in days, e.g. 90d, 36h or 30m
+func parseAge(text string) (time.Duration, error) {
+	if strings.HasSuffix(text, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(text, "d"))
+
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", text)
+		}
+
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(text)
+}
+
+// auditRetention is how long the records are kept, AUDIT_RETENTION or 90 days by default
+func auditRetention() time.Duration {
+	if retention, err := parseAge(workspaceSetting("", "AUDIT_RETENTION")); err == nil && retention > 0 {
+		return retention
+	}
+	return defaultAuditRetention
+}
+
+// record stores a command once it's handled
+// task is empty if no task understood the command
+func (a *auditLog) record(message Message, text, task string, duration time.Duration) auditRecord {
+	r := auditRecord{
+		ID:        message.CorrelationID,
+		Time:      time.Now(),
+		Workspace: message.Workspace,
+		Channel:   message.Channel,
+		User:      message.User,
+		Text:      redact(text),
+		Task:      task,
+		Duration:  duration,
+	}
+
+	if words := strings.Fields(r.Text); len(words) > 1 {
+		r.Args = words[1:]
+	}
+
+	switch {
+	case task == "":
+		r.Outcome = "unknown"
+	case task == "conversation":
+		r.Outcome = "answered"
+	default:
+		r.Outcome = "handled"
+	}
+
+	if reported, ok := a.takeReported(r.ID); ok {
+		r.Outcome, r.Error = reported.outcome, reported.err
+	}
+
+	if err := a.save(r); err != nil {
+		logs.error("cannot record the command in the audit log", "command", r.ID, "err", err)
+	}
+
+	// an outcome reported while the record was saved
+	if reported, ok := a.takeReported(r.ID); ok {
+		r.Outcome, r.Error = reported.outcome, reported.err
+
+		if err := a.save(r); err != nil {
+			logs.error("cannot record the outcome of the command in the audit log", "command", r.ID, "err", err)
+		}
+	}
+
+	return r
+}
+
+// complete reports how a command ended, e.g. a deploy that finished long after its command was handled
+// the outcome is kept until the command is recorded
+func (a *auditLog) complete(message Message, outcome string, err error) {
+	if message.CorrelationID == "" {
+		return
+	}
+
+	reported := auditOutcome{outcome: outcome}
+
+	if err != nil {
+		reported.err = redact(err.Error())
+	}
+
+	if _, err := a.store().Get(auditIDKey(message.CorrelationID)); err == brain.ErrNotFound {
+		a.mu.Lock()
+		a.reported[message.CorrelationID] = reported
+		a.mu.Unlock()
+
+		// the command may have been recorded in the meantime, without this outcome
+		if _, err := a.store().Get(auditIDKey(message.CorrelationID)); err == brain.ErrNotFound {
+			return
+		}
+
+		var ok bool
+		if reported, ok = a.takeReported(message.CorrelationID); !ok {
+			return
+		}
+	}
+
+	if err := a.saveOutcome(message.CorrelationID, reported); err != nil && err != brain.ErrNotFound {
+		// the record may have expired
+		logs.error("cannot record the outcome of the command in the audit log", "command", message.CorrelationID, "err", err)
+	}
+}
+
+// takeReported removes the outcome reported for a command that wasn't recorded yet
+func (a *auditLog) takeReported(id string) (auditOutcome, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	reported, ok := a.reported[id]
+	delete(a.reported, id)
+	return reported, ok
+}
+
+// saveOutcome changes the outcome of a recorded command
+func (a *auditLog) saveOutcome(id string, reported auditOutcome) error {
+	store := a.store()
+	key, err := store.Get(auditIDKey(id))
+
+	if err != nil {
+		return err
+	}
+
+	value, err := store.Get(string(key))
+
+	if err != nil {
+		return err
+	}
+
+	var r auditRecord
+
+	if err := json.Unmarshal(value, &r); err != nil {
+		return err
+	}
+
+	r.Outcome, r.Error = reported.outcome, reported.err
+	return a.save(r)
+}
+
+// save stores a record and the key to find it by correlation ID,
+// both expire AUDIT_RETENTION after the command
+func (a *auditLog) save(r auditRecord) error {
+	ttl := auditRetention() - time.Since(r.Time)
+
+	if ttl <= 0 {
+		return nil
+	}
+
+	value, err := json.Marshal(r)
+
+	if err != nil {
+		return err
+	}
+
+	store := a.store()
+
+	if err := store.Set(auditKey(r), value, ttl); err != nil {
+		return err
+	}
+
+	return store.Set(auditIDKey(r.ID), []byte(auditKey(r)), ttl)
+}
+
+// query returns the records that match a filter, the oldest first
+// only the days since the start of the filter are read
+func (a *auditLog) query(filter auditFilter) ([]auditRecord, error) {
+	now := time.Now()
+	since := filter.Since
+
+	// older records have expired
+	if oldest := now.Add(-auditRetention()); since.Before(oldest) {
+		since = oldest
+	}
+
+	store := a.store()
+	var keys []string
+
+	for day := since.UTC().Truncate(24 * time.Hour); !day.After(now); day = day.Add(24 * time.Hour) {
+		dayKeys, err := store.List(auditDay(day))
+
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, dayKeys...)
+	}
+
+	from := auditKey(auditRecord{Time: since})
+	var records []auditRecord
+
+	for _, key := range keys {
+		if key < from {
+			continue
+		}
+
+		value, err := store.Get(key)
+
+		// the record expired since the keys were listed
+		if err == brain.ErrNotFound {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		var r auditRecord
+
+		if err := json.Unmarshal(value, &r); err != nil {
+			return nil, fmt.Errorf("invalid audit record %s: %v", key, err)
+		}
+
+		if filter.match(r) {
+			records = append(records, r)
+		}
+	}
+
+	return records, nil
+}
+
+// recordCommand remembers a command for the dashboard and the audit log
+func recordCommand(message Message, text, task string, start time.Time) {
+	duration := time.Since(start)
+	recentCommands.add(message, text, task, duration)
+	audit.record(message, text, task, duration)
+}
+
+// parseSince reads when the records start: a duration such as 2h or 7d,
+// or a day such as 2016-01-02
+func parseSince(text string, now time.Time) (time.Time, bool) {
+	if age, err := parseAge(text); err == nil && age > 0 {
+		return now.Add(-age), true
+	}
+
+	if t, err := time.ParseInLocation("2006-01-02", text, now.Location()); err == nil {
+		return t, true
+	}
+
+	return time.Time{}, false
+}
+
+// a user mention, e.g. <@U024BE7LH>
+var mentionPattern = regexp.MustCompile(`^<@(\w+)>$`)
+
+// a Slack user ID, e.g. U024BE7LH, or a Mattermost one, 26 lowercase letters and digits
+var userIDPattern = regexp.MustCompile(`^([UW][A-Z0-9]{2,}|[a-z0-9]{26})$`)
+
+// parseAuditFilter reads the options of `@mario audit [user|task] [since]`,
+// the records of the last day by default, it fails on an option that's none of them
+func parseAuditFilter(message Message, options []string, now time.Time) (auditFilter, bool) {
+	filter := auditFilter{Workspace: message.Workspace, Since: now.Add(-24 * time.Hour)}
+
+	for _, option := range options {
+		if since, ok := parseSince(option, now); ok {
+			filter.Since = since
+			continue
+		}
+
+		if match := mentionPattern.FindStringSubmatch(option); match != nil {
+			filter.User = match[1]
+			continue
+		}
+
+		isTask := option == "conversation"
+		for _, task := range tasks {
+			isTask = isTask || task.getName() == option
+		}
+
+		switch {
+		case isTask:
+			filter.Task = option
+		case userIDPattern.MatchString(option):
+			filter.User = option
+		default:
+			return filter, false
+		}
+	}
+
+	return filter, true
+}
+
+// formatAuditRecord describes a record on a single line
+func formatAuditRecord(r auditRecord) string {
+	text := fmt.Sprintf("%s <@%s> in %s: `%s`", r.Time.Format("2006-01-02 15:04"), r.User, r.Channel, r.Text)
+
+	if r.Task != "" {
+		text += " → " + r.Task
+	}
+
+	text += ", " + r.Outcome
+
+	if r.Error != "" {
+		text += " (" + r.Error + ")"
+	}
+
+	return text + fmt.Sprintf(" in %s", r.Duration.Truncate(time.Millisecond))
+}
+
+func (s Audit) Hear(slack chatAgent, message Message, input string) bool {
+	options := strings.Fields(input)
+
+	if len(options) == 0 || options[0] != "audit" {
+		return false
+	}
+
+	var err error
+
+	switch {
+	case len(options) > 1 && options[1] == "help":
+		err = s.Help(slack, message)
+	case !isAdmin(message.Workspace, message.User):
+		message.Text = "Sorry, only an admin can read the audit log."
+		err = slack.postMessage(message)
+	default:
+		filter, ok := parseAuditFilter(message, options[1:], time.Now())
+
+		if !ok || len(options) > 3 {
+			message.Text = "Usage: @mario audit [user|task] [since]"
+			err = slack.postMessage(message)
+		} else {
+			err = s.list(slack, message, filter)
+		}
+	}
+
+	if err != nil {
+		taskLog(s, message).error("cannot post the audit log", "err", err)
+	}
+	return true
+}
+
+// list posts the last records that match a filter
+func (s Audit) list(slack chatAgent, message Message, filter auditFilter) error {
+	records, err := audit.query(filter)
+
+	if err != nil {
+		message.Text = "Sorry, I couldn't read the audit log: " + err.Error()
+		slack.postMessage(message)
+		return err
+	}
+
+	since := filter.Since.Format("2006-01-02 15:04")
+
+	if len(records) == 0 {
+		message.Text = "No command since " + since + "."
+		return slack.postMessage(message)
+	}
+
+	message.Text = fmt.Sprintf("%d commands since %s", len(records), since)
+
+	if len(records) == 1 {
+		message.Text = "1 command since " + since
+	}
+
+	if len(records) > auditChatLimit {
+		message.Text += fmt.Sprintf(", the last %d", auditChatLimit)
+		records = records[len(records)-auditChatLimit:]
+	}
+
+	message.Text += ":\n"
+	for i := len(records) - 1; i >= 0; i-- {
+		message.Text += "- " + formatAuditRecord(records[i]) + "\n"
+	}
+
+	return slack.postMessage(message)
+}
+
+func (s Audit) Help(slack chatAgent, message Message) error {
+	message.Text = `<audit> tells the admins who asked me to do what.
+Usage:
+- @mario audit [user|task] [since]
+
+The user is a mention (@alice) or an ID, the task a command name such as deploy.
+Since is a duration (2h, 7d) or a day (2016-01-02), the last day by default.
+`
+
+	err := slack.postMessage(message)
+
+	if err != nil {
+		taskLog(s, message).error("cannot post the help", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s Audit) getName() string {
+	return "audit"
+}
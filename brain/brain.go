@@ -0,0 +1,110 @@
+// Package brain is what Mario remembers across restarts,
+// a key-value store with an in-memory, an on-disk and a Redis backend
+package brain
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when a key doesn't exist or expired
+var ErrNotFound = errors.New("brain: key not found")
+
+// Store is a key-value store
+// a TTL of 0 keeps a key until it's deleted
+type Store interface {
+	// Get returns the value of a key, or ErrNotFound
+	Get(key string) ([]byte, error)
+	// Set sets the value of a key
+	Set(key string, value []byte, ttl time.Duration) error
+	// Delete removes a key, removing a missing key isn't an error
+	Delete(key string) error
+	// List returns the keys starting with prefix, sorted
+	List(prefix string) ([]string, error)
+	// Incr adds delta to the integer value of a key, a missing key is 0
+	// the TTL of the key doesn't change
+	// Returns the new value
+	Incr(key string, delta int64) (int64, error)
+	// CompareAndSwap sets the value of a key if its current value is old,
+	// a nil old value means the key must not exist, a nil value deletes the key
+	// Returns false if the value was different
+	CompareAndSwap(key string, old, value []byte, ttl time.Duration) (bool, error)
+	// Close releases the resources of the store
+	Close() error
+}
+
+// Open opens the store described by a URL:
+// "memory", "file:<path>" for the on-disk store or "redis://[:password@]host:port[/db]",
+// "rediss://" connects to Redis over TLS
+func Open(rawurl string) (Store, error) {
+	switch {
+	case rawurl == "memory":
+		return NewMemory(), nil
+
+	case strings.HasPrefix(rawurl, "file:"):
+		path := strings.TrimPrefix(strings.TrimPrefix(rawurl, "file:"), "//")
+		if path == "" {
+			return nil, fmt.Errorf("brain: %q has no path", rawurl)
+		}
+		return OpenFile(path)
+
+	case strings.HasPrefix(rawurl, "redis://"), strings.HasPrefix(rawurl, "rediss://"):
+		u, err := url.Parse(rawurl)
+		if err != nil {
+			return nil, fmt.Errorf("brain: invalid Redis URL: %v", err)
+		}
+		return OpenRedis(u)
+	}
+
+	return nil, fmt.Errorf("brain: unknown store %q, use memory, file:<path>, redis://<host>:<port> or rediss://<host>:<port>", rawurl)
+}
+
+// namespace prefixes the keys of a store
+type namespace struct {
+	Store
+	prefix string
+}
+
+// Namespace returns a view of a store where every key is prefixed with "<name>:",
+// so that the users of a store cannot overwrite each other's keys
+func Namespace(store Store, name string) Store {
+	return &namespace{Store: store, prefix: name + ":"}
+}
+
+func (n *namespace) Get(key string) ([]byte, error) {
+	return n.Store.Get(n.prefix + key)
+}
+
+func (n *namespace) Set(key string, value []byte, ttl time.Duration) error {
+	return n.Store.Set(n.prefix+key, value, ttl)
+}
+
+func (n *namespace) Delete(key string) error {
+	return n.Store.Delete(n.prefix + key)
+}
+
+func (n *namespace) List(prefix string) ([]string, error) {
+	keys, err := n.Store.List(n.prefix + prefix)
+
+	for i := range keys {
+		keys[i] = strings.TrimPrefix(keys[i], n.prefix)
+	}
+
+	return keys, err
+}
+
+func (n *namespace) Incr(key string, delta int64) (int64, error) {
+	return n.Store.Incr(n.prefix+key, delta)
+}
+
+func (n *namespace) CompareAndSwap(key string, old, value []byte, ttl time.Duration) (bool, error) {
+	return n.Store.CompareAndSwap(n.prefix+key, old, value, ttl)
+}
+
+// Close doesn't close the underlying store, it's shared with other namespaces
+func (n *namespace) Close() error {
+	return nil
+}
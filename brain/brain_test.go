@@ -0,0 +1,242 @@
+package brain
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testStore checks the behaviour every store must have
+func testStore(t *testing.T, store Store) {
+	if _, err := store.Get("missing"); err != ErrNotFound {
+		t.Errorf("Expected a missing key not to be found, got %v", err)
+	}
+
+	if err := store.Set("greeting", []byte("hello"), 0); err != nil {
+		t.Fatalf("Expected no error setting a key, got %v", err)
+	}
+
+	if value, err := store.Get("greeting"); err != nil || string(value) != "hello" {
+		t.Errorf("Expected hello, got %q, %v", value, err)
+	}
+
+	// TTL
+	store.Set("short", []byte("lived"), 50*time.Millisecond)
+	if _, err := store.Get("short"); err != nil {
+		t.Errorf("Expected a key to exist until it expires, got %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if _, err := store.Get("short"); err != ErrNotFound {
+		t.Errorf("Expected an expired key not to be found, got %v", err)
+	}
+
+	// list by prefix
+	store.Set("lock/b", []byte("2"), 0)
+	store.Set("lock/a", []byte("1"), 0)
+	store.Set("lock*", []byte("glob"), 0)
+	store.Set("locks", []byte("3"), 0)
+
+	if keys, err := store.List("lock/"); err != nil || !reflect.DeepEqual(keys, []string{"lock/a", "lock/b"}) {
+		t.Errorf("Expected the keys starting with lock/, got %v, %v", keys, err)
+	}
+
+	if keys, err := store.List("lock*"); err != nil || !reflect.DeepEqual(keys, []string{"lock*"}) {
+		t.Errorf("Expected prefixes not to be patterns, got %v, %v", keys, err)
+	}
+
+	// delete
+	if err := store.Delete("lock/a"); err != nil {
+		t.Errorf("Expected no error deleting a key, got %v", err)
+	}
+
+	if err := store.Delete("lock/a"); err != nil {
+		t.Errorf("Expected no error deleting a missing key, got %v", err)
+	}
+
+	if _, err := store.Get("lock/a"); err != ErrNotFound {
+		t.Errorf("Expected a deleted key not to be found, got %v", err)
+	}
+
+	// atomic increment
+	if n, err := store.Incr("counter", 2); err != nil || n != 2 {
+		t.Errorf("Expected a missing counter to start at 0, got %d, %v", n, err)
+	}
+
+	if n, err := store.Incr("counter", -5); err != nil || n != -3 {
+		t.Errorf("Expected the counter to be -3, got %d, %v", n, err)
+	}
+
+	if _, err := store.Incr("greeting", 1); err == nil {
+		t.Errorf("Expected a text not to be incremented")
+	}
+
+	// compare and swap
+	if ok, err := store.CompareAndSwap("owner", nil, []byte("alice"), 0); !ok || err != nil {
+		t.Errorf("Expected a missing key to be created, got %t, %v", ok, err)
+	}
+
+	if ok, err := store.CompareAndSwap("owner", nil, []byte("bob"), 0); ok || err != nil {
+		t.Errorf("Expected an existing key not to be created again, got %t, %v", ok, err)
+	}
+
+	if ok, err := store.CompareAndSwap("owner", []byte("bob"), []byte("carol"), 0); ok || err != nil {
+		t.Errorf("Expected a different value not to be swapped, got %t, %v", ok, err)
+	}
+
+	if ok, err := store.CompareAndSwap("owner", []byte("alice"), []byte("bob"), time.Hour); !ok || err != nil {
+		t.Errorf("Expected the value to be swapped, got %t, %v", ok, err)
+	}
+
+	if value, _ := store.Get("owner"); string(value) != "bob" {
+		t.Errorf("Expected bob, got %q", value)
+	}
+
+	store.Set("visitor", []byte("carol"), 0)
+
+	if ok, err := store.CompareAndSwap("visitor", []byte("alice"), nil, 0); ok || err != nil {
+		t.Errorf("Expected a different value not to be deleted, got %t, %v", ok, err)
+	}
+
+	if ok, err := store.CompareAndSwap("visitor", []byte("carol"), nil, 0); !ok || err != nil {
+		t.Errorf("Expected the key to be deleted, got %t, %v", ok, err)
+	}
+
+	if _, err := store.Get("visitor"); err != ErrNotFound {
+		t.Errorf("Expected the deleted key to be missing, got %v", err)
+	}
+
+	// namespaces
+	tasks := Namespace(store, "tasks")
+	tasks.Set("greeting", []byte("yo"), 0)
+
+	if value, _ := store.Get("greeting"); string(value) != "hello" {
+		t.Errorf("Expected a namespace not to overwrite other keys, got %q", value)
+	}
+
+	if keys, err := tasks.List(""); err != nil || !reflect.DeepEqual(keys, []string{"greeting"}) {
+		t.Errorf("Expected the keys of the namespace, got %v, %v", keys, err)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testStore(t, NewMemory())
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "brain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "brain.json")
+	store, err := Open("file:" + path)
+	if err != nil {
+		t.Fatalf("Expected a new file store, got %v", err)
+	}
+
+	testStore(t, store)
+
+	// the changes are written together, at the latest when the store is closed
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the changes not to be written yet, got %v", err)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("Expected the store to be written, got %v", err)
+	}
+
+	// everything survives a restart
+	reopened, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("Expected the file store to be reopened, got %v", err)
+	}
+	reopened.delay = time.Millisecond
+
+	if value, err := reopened.Get("owner"); err != nil || string(value) != "bob" {
+		t.Errorf("Expected bob to be remembered, got %q, %v", value, err)
+	}
+
+	if n, err := reopened.Incr("counter", 1); err != nil || n != -2 {
+		t.Errorf("Expected the counter to be remembered, got %d, %v", n, err)
+	}
+
+	// and is written shortly after it changes
+	reopened.Set("owner", []byte("carol"), 0)
+	time.Sleep(50 * time.Millisecond)
+
+	again, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value, err := again.Get("owner"); string(value) != "carol" {
+		t.Errorf("Expected the change to be written after the delay, got %q, %v", value, err)
+	}
+
+	ioutil.WriteFile(path, []byte("not json"), 0600)
+	if _, err := OpenFile(path); err == nil {
+		t.Errorf("Expected a corrupted file to be reported")
+	}
+}
+
+// set BRAIN_TEST_REDIS to the URL of a Redis database to test the Redis store,
+// its keys prefixed with "mario-test:" are deleted
+func TestRedis(t *testing.T) {
+	rawurl := os.Getenv("BRAIN_TEST_REDIS")
+	if rawurl == "" {
+		t.Skip("BRAIN_TEST_REDIS isn't set")
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := u.Query()
+	query.Set("prefix", "mario-test:")
+	u.RawQuery = query.Encode()
+
+	store, err := OpenRedis(u)
+	if err != nil {
+		t.Fatalf("Expected to connect to Redis, got %v", err)
+	}
+	defer store.Close()
+
+	clean := func() {
+		keys, _ := store.List("")
+		for _, key := range keys {
+			store.Delete(key)
+		}
+	}
+
+	clean()
+	defer clean()
+
+	testStore(t, store)
+}
+
+func TestOpen(t *testing.T) {
+	if store, err := Open("memory"); err != nil || store == nil {
+		t.Errorf("Expected a memory store, got %v", err)
+	}
+
+	for _, rawurl := range []string{"", "file:", "postgres://localhost", "redis://%zz"} {
+		if _, err := Open(rawurl); err == nil {
+			t.Errorf("Expected %q to be refused", rawurl)
+		}
+	}
+
+	for _, rawurl := range []string{"redis://127.0.0.1:1", "rediss://127.0.0.1:1?skip-verify"} {
+		if _, err := Open(rawurl); err == nil || strings.Contains(err.Error(), "unknown store") {
+			t.Errorf("Expected an unreachable Redis to be reported for %q, got %v", rawurl, err)
+		}
+	}
+}
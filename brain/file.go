@@ -0,0 +1,120 @@
+package brain
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// File is a store kept in memory and written to a JSON file a moment after it changes,
+// so that a burst of changes is written at once, and when it's closed.
+// the file is replaced at once so that a crash cannot leave it half written
+type File struct {
+	*Memory
+	path string
+	// delay is how long the store waits for more changes before writing them
+	delay time.Duration
+	// pending is the write scheduled after a change, nil once it's done
+	pending *time.Timer
+	// err is why the last write failed, the next change returns it
+	err error
+}
+
+// how long the file store waits for more changes before writing them
+const fileWriteDelay = time.Second
+
+// OpenFile opens the store saved in path, a missing file is an empty store
+func OpenFile(path string) (*File, error) {
+	f := &File{Memory: NewMemory(), path: path, delay: fileWriteDelay}
+
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err == nil {
+		if err := json.Unmarshal(data, &f.entries); err != nil {
+			return nil, err
+		}
+	}
+
+	f.changed = f.schedule
+	return f, nil
+}
+
+// schedule writes the changes after the delay, unless a write is already scheduled,
+// the caller holds the lock
+// Returns the error of the last write
+func (f *File) schedule() error {
+	if f.pending == nil {
+		f.pending = time.AfterFunc(f.delay, f.flush)
+	}
+
+	err := f.err
+	f.err = nil
+	return err
+}
+
+// flush writes the scheduled changes
+func (f *File) flush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.pending = nil
+	f.err = f.write()
+}
+
+// Close writes the changes that are still scheduled
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.pending == nil {
+		return f.err
+	}
+
+	f.pending.Stop()
+	f.pending = nil
+
+	return f.write()
+}
+
+// write saves the entries that haven't expired, the caller holds the lock
+func (f *File) write() error {
+	now := time.Now()
+	current := map[string]entry{}
+
+	for key, e := range f.entries {
+		if !e.expired(now) {
+			current[key] = e
+		}
+	}
+
+	data, err := json.Marshal(current)
+
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path)+".tmp")
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(data)
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), f.path)
+}
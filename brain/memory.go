@@ -0,0 +1,157 @@
+package brain
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// entry is a value and when it expires, a zero time never expires
+type entry struct {
+	Value   []byte    `json:"value"`
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+func (e entry) expired(now time.Time) bool {
+	return !e.Expires.IsZero() && !now.Before(e.Expires)
+}
+
+// Memory is a store that forgets everything when Mario stops
+type Memory struct {
+	mu      sync.Mutex
+	entries map[string]entry
+	// changed is called after every change, with the lock held
+	changed func() error
+}
+
+// NewMemory creates an empty in-memory store
+func NewMemory() *Memory {
+	return &Memory{entries: map[string]entry{}}
+}
+
+func expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// lookup returns the entry of a key, the caller must hold the lock
+func (m *Memory) lookup(key string) (entry, bool) {
+	e, ok := m.entries[key]
+
+	if ok && e.expired(time.Now()) {
+		delete(m.entries, key)
+		return entry{}, false
+	}
+
+	return e, ok
+}
+
+func (m *Memory) save() error {
+	if m.changed == nil {
+		return nil
+	}
+	return m.changed()
+}
+
+func (m *Memory) Get(key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.lookup(key)
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return append([]byte(nil), e.Value...), nil
+}
+
+func (m *Memory) Set(key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[key] = entry{Value: append([]byte(nil), value...), Expires: expiry(ttl)}
+	return m.save()
+}
+
+func (m *Memory) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.entries[key]; !ok {
+		return nil
+	}
+
+	delete(m.entries, key)
+	return m.save()
+}
+
+func (m *Memory) List(prefix string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var keys []string
+
+	for key, e := range m.entries {
+		if strings.HasPrefix(key, prefix) && !e.expired(now) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+func (m *Memory) Incr(key string, delta int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, _ := m.lookup(key)
+	var n int64
+
+	if e.Value != nil {
+		var err error
+		n, err = strconv.ParseInt(string(e.Value), 10, 64)
+
+		if err != nil {
+			return 0, fmt.Errorf("brain: %s isn't an integer", key)
+		}
+	}
+
+	n += delta
+	e.Value = []byte(strconv.FormatInt(n, 10))
+	m.entries[key] = e
+
+	return n, m.save()
+}
+
+func (m *Memory) CompareAndSwap(key string, old, value []byte, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.lookup(key)
+
+	if (old == nil && ok) || (old != nil && (!ok || !bytes.Equal(e.Value, old))) {
+		return false, nil
+	}
+
+	if value == nil {
+		delete(m.entries, key)
+	} else {
+		m.entries[key] = entry{Value: append([]byte(nil), value...), Expires: expiry(ttl)}
+	}
+
+	return true, m.save()
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
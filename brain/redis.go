@@ -0,0 +1,186 @@
+package brain
+
+import (
+	"github.com/umbrellium/mario/Godeps/_workspace/src/github.com/garyburd/redigo/redis"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Redis is a store kept in a Redis database, shared by every Mario using it
+type Redis struct {
+	pool *redis.Pool
+	// every key is prefixed, "mario:" by default
+	prefix string
+}
+
+// casScript sets a key if its value is ARGV[1], or if it doesn't exist when ARGV[3] is "1"
+// ARGV[2] is the new value and ARGV[4] the TTL in milliseconds, 0 to keep the key forever,
+// the key is deleted instead when ARGV[5] is "1"
+var casScript = redis.NewScript(1, `
+local current = redis.call("GET", KEYS[1])
+if ARGV[3] == "1" then
+	if current then return 0 end
+elseif current ~= ARGV[1] then
+	return 0
+end
+if ARGV[5] == "1" then
+	redis.call("DEL", KEYS[1])
+elseif ARGV[4] == "0" then
+	redis.call("SET", KEYS[1], ARGV[2])
+else
+	redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[4])
+end
+return 1
+`)
+
+// OpenRedis connects to the Redis database of a URL such as redis://:password@localhost:6379/0,
+// or over TLS with rediss://, the prefix query parameter changes the prefix of the keys
+// and skip-verify accepts the self-signed certificates of Heroku Redis
+func OpenRedis(u *url.URL) (*Redis, error) {
+	prefix := "mario:"
+	if _, ok := u.Query()["prefix"]; ok {
+		prefix = u.Query().Get("prefix")
+	}
+	_, skipVerify := u.Query()["skip-verify"]
+
+	address := u.String()
+	if u.RawQuery != "" {
+		address = strings.SplitN(address, "?", 2)[0]
+	}
+
+	r := &Redis{
+		prefix: prefix,
+		pool: &redis.Pool{
+			MaxIdle:     3,
+			IdleTimeout: 5 * time.Minute,
+			Dial: func() (redis.Conn, error) {
+				return redis.DialURL(address, redis.DialConnectTimeout(5*time.Second),
+					redis.DialReadTimeout(5*time.Second), redis.DialWriteTimeout(5*time.Second),
+					redis.DialTLSSkipVerify(skipVerify))
+			},
+		},
+	}
+
+	// check the database can be reached
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("PING"); err != nil {
+		r.pool.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *Redis) do(command string, args ...interface{}) (interface{}, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	return conn.Do(command, args...)
+}
+
+func milliseconds(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	if ms := int64(ttl / time.Millisecond); ms > 0 {
+		return ms
+	}
+	return 1
+}
+
+func (r *Redis) Get(key string) ([]byte, error) {
+	value, err := redis.Bytes(r.do("GET", r.prefix+key))
+
+	if err == redis.ErrNil {
+		return nil, ErrNotFound
+	}
+
+	return value, err
+}
+
+func (r *Redis) Set(key string, value []byte, ttl time.Duration) error {
+	if ms := milliseconds(ttl); ms > 0 {
+		_, err := r.do("SET", r.prefix+key, value, "PX", ms)
+		return err
+	}
+
+	_, err := r.do("SET", r.prefix+key, value)
+	return err
+}
+
+func (r *Redis) Delete(key string) error {
+	_, err := r.do("DEL", r.prefix+key)
+	return err
+}
+
+// globEscaper escapes the characters of a key that SCAN MATCH treats as a pattern
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+func (r *Redis) List(prefix string) ([]string, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	seen := map[string]bool{}
+	cursor := "0"
+
+	for {
+		reply, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", globEscaper.Replace(r.prefix+prefix)+"*", "COUNT", 100))
+
+		if err != nil {
+			return nil, err
+		}
+
+		var keys []string
+		if _, err := redis.Scan(reply, &cursor, &keys); err != nil {
+			return nil, err
+		}
+
+		// SCAN may return a key more than once
+		for _, key := range keys {
+			seen[strings.TrimPrefix(key, r.prefix)] = true
+		}
+
+		if cursor == "0" {
+			break
+		}
+	}
+
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+func (r *Redis) Incr(key string, delta int64) (int64, error) {
+	return redis.Int64(r.do("INCRBY", r.prefix+key, delta))
+}
+
+func (r *Redis) CompareAndSwap(key string, old, value []byte, ttl time.Duration) (bool, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	missing := "0"
+	if old == nil {
+		missing = "1"
+	}
+
+	remove := "0"
+	if value == nil {
+		remove = "1"
+	}
+
+	return redis.Bool(casScript.Do(conn, r.prefix+key, old, value, missing, strconv.FormatInt(milliseconds(ttl), 10), remove))
+}
+
+func (r *Redis) Close() error {
+	return r.pool.Close()
+}
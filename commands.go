@@ -5,12 +5,7 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"net/http"
-	"os"
 	"regexp"
 	"strings"
 )
@@ -32,6 +27,12 @@ func init() {
 	tasks = append(tasks, Hello{})
 	tasks = append(tasks, Say{})
 	tasks = append(tasks, Wercker{})
+	tasks = append(tasks, WerckerBuilds{})
+	tasks = append(tasks, WerckerDeploy{})
+	tasks = append(tasks, DeployHistory{})
+	tasks = append(tasks, WerckerLogs{})
+	tasks = append(tasks, Locks{})
+	tasks = append(tasks, Audit{})
 }
 
 // Hello Task
@@ -46,7 +47,7 @@ func (h Hello) Hear(slack chatAgent, message Message, input string) bool {
 	// parse input and check if 'hello' is the first word
 	r, err := regexp.Compile(`(?i)^\bhello\b`)
 	if err != nil {
-		fmt.Println("Error parsing Hello input")
+		taskLog(h, message).error("cannot parse the input", "err", err)
 	}
 
 	if r.MatchString(input) {
@@ -62,7 +63,7 @@ func (h Hello) Hear(slack chatAgent, message Message, input string) bool {
 			err := Hello.Help(h, slack, message)
 
 			if err != nil {
-				fmt.Println("Error posting message to slack")
+				taskLog(h, message).error("cannot post message", "err", err)
 				return false
 			}
 			return true
@@ -80,7 +81,7 @@ This command doesn't take any other options`
 	err := slack.postMessage(message)
 
 	if err != nil {
-		log.Fatal(err)
+		taskLog(s, message).error("cannot post message", "err", err)
 		return err
 	}
 	return nil
@@ -97,7 +98,7 @@ func (s Hello) say(slack chatAgent, message Message) error {
 	err := slack.postMessage(message)
 
 	if err != nil {
-		fmt.Println(err)
+		taskLog(s, message).error("cannot post message", "err", err)
 		return err
 	}
 
@@ -115,7 +116,7 @@ func (s Help) Hear(slack chatAgent, message Message, input string) bool {
 	r, err := regexp.Compile(`(?i)^\bhelp\b`)
 
 	if err != nil {
-		fmt.Println("Error parsing Help input")
+		taskLog(s, message).error("cannot parse the input", "err", err)
 	}
 
 	if r.MatchString(input) {
@@ -126,7 +127,7 @@ func (s Help) Hear(slack chatAgent, message Message, input string) bool {
 			err := s.Help(slack, message)
 
 			if err != nil {
-				fmt.Println("Error posting message to slack")
+				taskLog(s, message).error("cannot post message", "err", err)
 				return false
 			}
 
@@ -143,7 +144,7 @@ func (s Help) Hear(slack chatAgent, message Message, input string) bool {
 Did you mean "@mario help" ?`
 			err := slack.postMessage(message)
 			if err != nil {
-				log.Fatal(err)
+				taskLog(s, message).error("cannot post message", "err", err)
 			}
 			return true
 		}
@@ -171,7 +172,7 @@ Here is a list of the tasks I can currently perform:
 	err := slack.postMessage(message)
 
 	if err != nil {
-		log.Fatal(err)
+		taskLog(s, message).error("cannot post message", "err", err)
 		return err
 	}
 
@@ -203,7 +204,7 @@ Type "@mario help" for a list of tasks I can perfom.`
 		err := slack.postMessage(message)
 
 		if err != nil {
-			log.Fatal(err)
+			taskLog(s, message).error("cannot post message", "err", err)
 		}
 	}
 }
@@ -219,7 +220,7 @@ func (s Say) Hear(slack chatAgent, message Message, input string) bool {
 	r, err := regexp.Compile(`(?i)^\bsay\b`)
 
 	if err != nil {
-		fmt.Println("Error parsing Help input")
+		taskLog(s, message).error("cannot parse the input", "err", err)
 	}
 
 	if r.MatchString(input) {
@@ -230,7 +231,7 @@ func (s Say) Hear(slack chatAgent, message Message, input string) bool {
 			err := Say.Help(s, slack, message)
 
 			if err != nil {
-				fmt.Println("Error parsing Hear option")
+				taskLog(s, message).error("cannot post message", "err", err)
 				return false
 			}
 			return true
@@ -248,7 +249,7 @@ func (s Say) Help(slack chatAgent, message Message) error {
 	err := slack.postMessage(message)
 
 	if err != nil {
-		fmt.Println(err)
+		taskLog(s, message).error("cannot post message", "err", err)
 		return err
 	}
 
@@ -260,126 +261,3 @@ func (s Say) Help(slack chatAgent, message Message) error {
 func (s Say) getName() string {
 	return "say"
 }
-
-// Wercker struct
-// performs Wercker realted tasks (e.g. list apps, deploy app etc)
-type Wercker struct {
-}
-
-type werkerApps struct {
-	Name string `json:name`
-}
-
-func (s Wercker) Hear(slack chatAgent, message Message, input string) bool {
-	patter, err := regexp.Compile(`^\blist apps\b`)
-
-	if err != nil {
-		fmt.Println("Error parsing Help input")
-	}
-
-	if patter.MatchString(input) {
-		options := strings.Fields(input)
-
-		if len(options) == 2 {
-			res, err := Wercker.connectToAPI(s, "applications")
-			if err != nil {
-				fmt.Println("Error connecting to the Wercker API")
-				return false
-			}
-
-			Wercker.listApps(s, res, slack, message)
-			return true
-		}
-
-		if options[2] == "help" {
-			// call help
-			err := Wercker.Help(s, slack, message)
-			if err != nil {
-				fmt.Println("Error calling Wercker Help")
-				return false
-			}
-			return true
-		}
-	}
-	return false
-}
-
-func (s Wercker) connectToAPI(endpoint string) (*http.Response, error) {
-	wtoken := os.Getenv("WERCKER_TOKEN")
-	if wtoken == "" {
-		wtoken = os.Args[2]
-		// NOTE: token can be an empty string
-		// Wercker will retrun only public apps
-	}
-
-	var url string
-
-	switch endpoint {
-	case "applications":
-		url = "https://app.wercker.com/api/v3/applications/umbrellium?token=" + wtoken
-	case "builds":
-		url = "https://app.wercker.com/api/v3/builds/"
-	case "deploy":
-		url = "https://app.wercker.com/api/v3/deploys/"
-	}
-
-	res, err := http.Get(url)
-
-	if err != nil {
-		fmt.Println("Error: problem talking to Wercker API")
-		return nil, err
-	}
-
-	return res, nil
-}
-
-// Wercker listApps
-// prints a list of Umbrellium apps that are currently available on Wercker
-func (s Wercker) listApps(httpRes *http.Response, slack chatAgent, message Message) error {
-
-	var availbaleApps []werkerApps
-
-	// parse response
-	body, err := ioutil.ReadAll(httpRes.Body)
-	if err != nil {
-		fmt.Println("Error: problem reading response from Wercker API")
-		return err
-	}
-
-	json.Unmarshal(body, &availbaleApps)
-
-	message.Text = "The following apps are currently available on Wercker: \n"
-
-	// print response to slack
-	for _, app := range availbaleApps {
-		fmt.Println(app)
-		message.Text += app.Name + fmt.Sprintf("\n")
-	}
-
-	err2 := slack.postMessage(message)
-	if err2 != nil {
-		fmt.Println("Error: problem posting message to Slack")
-		return err2
-	}
-
-	return nil
-}
-
-func (s Wercker) Help(slack chatAgent, message Message) error {
-	message.Text = `<list apps> will list the Umbrellium applications currently available on Wercker. 
-This command does not take any option. 
-`
-
-	err := slack.postMessage(message)
-
-	if err != nil {
-		log.Fatal(err)
-		return err
-	}
-
-	return nil
-}
-
-func (s Wercker) getName() string {
-	return "list apps"
-}
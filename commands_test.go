@@ -1,16 +1,26 @@
 package main
 
 import (
+	"github.com/umbrellium/mario/wercker"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
 	"testing"
 )
 
 var slack FakeSlackChat
-var msg = Message{1, "", "", ""}
+var msg = Message{Id: 1}
 
 // FakeSlackChat is a fake slack requests struct
 // it implements the chatAgent interface defined in slack.go
 // so that it can replace the real slack requests when testing
-type FakeSlackChat struct{}
+// every message Mario posts is recorded
+type FakeSlackChat struct {
+	mu     sync.Mutex
+	posted []Message
+}
 
 // fakeSlackChat implement get message
 func (t *FakeSlackChat) getMessage() (Message, error) {
@@ -20,9 +30,23 @@ func (t *FakeSlackChat) getMessage() (Message, error) {
 
 // fakeSlackChat implement post message
 func (t *FakeSlackChat) postMessage(msg Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.posted = append(t.posted, msg)
 	return nil
 }
 
+// replies returns the messages posted since the last call
+func (t *FakeSlackChat) replies() []Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	posted := t.posted
+	t.posted = nil
+	return posted
+}
+
 // TestHelloCommand tests responses from the <hello> command
 func TestHelloHearCommand(t *testing.T) {
 	hello := new(Hello)
@@ -44,7 +68,7 @@ func TestHelloHearCommand(t *testing.T) {
 	for _, tst := range helloHearTest {
 		res := hello.Hear(&slack, msg, tst.input)
 		if res != tst.expected {
-			t.Errorf("Expected %q to return %q, got %q instead", tst.input, tst.expected, res)
+			t.Errorf("Expected %q to return %t, got %t instead", tst.input, tst.expected, res)
 		}
 	}
 }
@@ -70,7 +94,7 @@ func TestHelloHearHelp(t *testing.T) {
 	for _, tst := range helloHearHelp {
 		res := hello.Hear(&slack, msg, tst.input)
 		if res != tst.expected {
-			t.Errorf("Expected %q to return %q, got %q instead", tst.input, tst.expected, res)
+			t.Errorf("Expected %q to return %t, got %t instead", tst.input, tst.expected, res)
 		}
 	}
 }
@@ -79,14 +103,80 @@ func TestHelloHearHelp(t *testing.T) {
 func TestHelloSay(t *testing.T) {
 	hello := new(Hello)
 
-	err := hello.say(&slack, msg)
+	var chat FakeSlackChat
+	err := hello.say(&chat, msg)
 
 	if err != nil {
 		t.Errorf("Expected Hello.say to return no error")
 	}
+
+	if replies := chat.replies(); len(replies) != 1 || replies[0].Text != "Yo!" {
+		t.Errorf("Expected Hello.say to post Yo!, got %+v", replies)
+	}
 }
 
 // test parse wercker list app
 func TestWerckerListApps(t *testing.T) {
-	// 	wercker := new(Wercker)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/api/v3/applications/umbrellium":
+			w.Write([]byte(`[{"name":"mario","privacy":"public","owner":{"name":"Umbrellium","meta":{"username":"umbrellium"}}},{"name":"luigi","privacy":"private","owner":{"name":"Umbrellium","meta":{"username":"umbrellium"}}}]`))
+		case "/api/v3/applications/client":
+			w.Write([]byte(`[{"name":"peach","privacy":"private","owner":{"name":"Client Ltd","meta":{"username":"client"}}}]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"statusCode":404,"message":"Owner not found"}`))
+		}
+	}))
+	defer server.Close()
+
+	defer func(client func(string) *wercker.Client) { werckerClient = client }(werckerClient)
+	werckerClient = func(workspace string) *wercker.Client {
+		client := wercker.NewClient("secret")
+		client.BaseURL = server.URL + "/api/v3/"
+		return client
+	}
+
+	os.Setenv("WERCKER_CHANNEL_ORGS", "C0CLIENT=client")
+	defer os.Unsetenv("WERCKER_CHANNEL_ORGS")
+
+	wercker := new(Wercker)
+	var chat FakeSlackChat
+
+	type werckerTestingStruct struct {
+		channel  string
+		input    string
+		expected string
+	}
+
+	werckerListAppsTest := []werckerTestingStruct{
+		{"C0GENERAL", "list apps", "mario (Umbrellium, public) https://app.wercker.com/umbrellium/mario\nluigi (Umbrellium, private) https://app.wercker.com/umbrellium/luigi\n"},
+		{"C0GENERAL", "list apps client", "peach (Client Ltd, private) https://app.wercker.com/client/peach\n"},
+		{"C0CLIENT", "list apps", "The following client apps"},
+		{"C0CLIENT", "list apps umbrellium", "The following umbrellium apps"},
+		{"C0GENERAL", "list apps bowser", "Owner not found"},
+		{"C0GENERAL", "list apps help", "Usage:"},
+	}
+
+	for _, tst := range werckerListAppsTest {
+		message := Message{Channel: tst.channel}
+
+		if !wercker.Hear(&chat, message, tst.input) {
+			t.Fatalf("Expected %q to be handled", tst.input)
+		}
+
+		replies := chat.replies()
+		if len(replies) != 1 || !strings.Contains(replies[0].Text, tst.expected) {
+			t.Errorf("Expected %q in %s to reply %q, got %+v", tst.input, tst.channel, tst.expected, replies)
+		}
+	}
+
+	if wercker.Hear(&chat, msg, "list apps client help") {
+		t.Errorf("Expected list apps with too many options not to be handled")
+	}
 }
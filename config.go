@@ -0,0 +1,485 @@
+package main
+
+import (
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"github.com/umbrellium/mario/Godeps/_workspace/src/gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var configPath = flag.String("config", "", "the YAML configuration file, mario.yml if it exists")
+
+// Config is Mario's configuration file
+// every setting can be overridden by an environment variable, see configSettings
+type Config struct {
+	Adapter string   `yaml:"adapter"`
+	Admins  []string `yaml:"admins"`
+	// AdminChannel is where Mario reports to the admins, e.g. configuration reloads
+	AdminChannel string `yaml:"admin_channel"`
+	Brain        string `yaml:"brain"`
+
+	HTTP struct {
+		Addr string `yaml:"addr"`
+		// AdminToken enables the admin API, requests must send it as a bearer token
+		AdminToken string `yaml:"admin_token"`
+		// DashboardPassword enables the dashboard, people log in with it
+		DashboardPassword string `yaml:"dashboard_password"`
+	} `yaml:"http"`
+
+	Slack struct {
+		Tokens []string `yaml:"tokens"`
+	} `yaml:"slack"`
+
+	IRC struct {
+		Server   string   `yaml:"server"`
+		Nick     string   `yaml:"nick"`
+		Channels []string `yaml:"channels"`
+		Password string   `yaml:"password"`
+		TLS      bool     `yaml:"tls"`
+	} `yaml:"irc"`
+
+	Mattermost struct {
+		URL   string `yaml:"url"`
+		Token string `yaml:"token"`
+	} `yaml:"mattermost"`
+
+	Wercker struct {
+		Token         string              `yaml:"token"`
+		URL           string              `yaml:"url"`
+		Org           string              `yaml:"org"`
+		ChannelOrgs   map[string]string   `yaml:"channel_orgs"`
+		Users         map[string]string   `yaml:"users"`
+		Deployers     map[string][]string `yaml:"deployers"`
+		Watch         map[string]string   `yaml:"watch"`
+		WatchInterval string              `yaml:"watch_interval"`
+		Webhook       struct {
+			Secret   string            `yaml:"secret"`
+			Channels map[string]string `yaml:"channels"`
+		} `yaml:"webhook"`
+	} `yaml:"wercker"`
+
+	Locks struct {
+		Channel string `yaml:"channel"`
+	} `yaml:"locks"`
+
+	Log struct {
+		Level string `yaml:"level"`
+	} `yaml:"log"`
+
+	Health struct {
+		MaxSilence string `yaml:"max_silence"`
+	} `yaml:"health"`
+
+	Audit struct {
+		// Retention is how long the commands are kept in the audit log, e.g. 90d
+		Retention string `yaml:"retention"`
+	} `yaml:"audit"`
+
+	// Workspaces overrides the settings of the Slack workspaces, by workspace ID
+	Workspaces map[string]*Config `yaml:"workspaces"`
+}
+
+// configSetting maps a setting of the configuration file to its environment variable
+// global settings cannot be set per workspace
+type configSetting struct {
+	name   string
+	path   string
+	global bool
+	value  func(c *Config) string
+}
+
+// joinPairs writes a map as "key=value,key=value"
+func joinPairs(pairs map[string]string) string {
+	var items []string
+	for key, value := range pairs {
+		items = append(items, key+"="+value)
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+var configSettings = []configSetting{
+	{"ADAPTER", "adapter", true, func(c *Config) string { return c.Adapter }},
+	{"MARIO_ADMINS", "admins", false, func(c *Config) string { return strings.Join(c.Admins, ",") }},
+	{"ADMIN_CHANNEL", "admin_channel", false, func(c *Config) string { return c.AdminChannel }},
+	{"BRAIN", "brain", true, func(c *Config) string { return c.Brain }},
+	{"HTTP_ADDR", "http.addr", true, func(c *Config) string { return c.HTTP.Addr }},
+	{"ADMIN_API_TOKEN", "http.admin_token", true, func(c *Config) string { return c.HTTP.AdminToken }},
+	{"DASHBOARD_PASSWORD", "http.dashboard_password", true, func(c *Config) string { return c.HTTP.DashboardPassword }},
+	{"TOKEN", "slack.tokens", true, func(c *Config) string { return strings.Join(c.Slack.Tokens, ",") }},
+	{"IRC_SERVER", "irc.server", true, func(c *Config) string { return c.IRC.Server }},
+	{"IRC_NICK", "irc.nick", true, func(c *Config) string { return c.IRC.Nick }},
+	{"IRC_CHANNELS", "irc.channels", true, func(c *Config) string { return strings.Join(c.IRC.Channels, ",") }},
+	{"IRC_PASSWORD", "irc.password", true, func(c *Config) string { return c.IRC.Password }},
+	{"IRC_TLS", "irc.tls", true, func(c *Config) string {
+		if c.IRC.TLS {
+			return "true"
+		}
+		return ""
+	}},
+	{"MATTERMOST_URL", "mattermost.url", true, func(c *Config) string { return c.Mattermost.URL }},
+	{"MATTERMOST_TOKEN", "mattermost.token", true, func(c *Config) string { return c.Mattermost.Token }},
+	{"WERCKER_TOKEN", "wercker.token", false, func(c *Config) string { return c.Wercker.Token }},
+	{"WERCKER_URL", "wercker.url", true, func(c *Config) string { return c.Wercker.URL }},
+	{"WERCKER_ORG", "wercker.org", false, func(c *Config) string { return c.Wercker.Org }},
+	{"WERCKER_CHANNEL_ORGS", "wercker.channel_orgs", false, func(c *Config) string { return joinPairs(c.Wercker.ChannelOrgs) }},
+	{"WERCKER_USERS", "wercker.users", false, func(c *Config) string { return joinPairs(c.Wercker.Users) }},
+	{"WERCKER_DEPLOYERS", "wercker.deployers", false, func(c *Config) string {
+		pairs := map[string]string{}
+		for target, users := range c.Wercker.Deployers {
+			pairs[target] = strings.Join(users, "|")
+		}
+		return joinPairs(pairs)
+	}},
+	{"WERCKER_WATCH", "wercker.watch", false, func(c *Config) string { return joinPairs(c.Wercker.Watch) }},
+	{"WERCKER_WATCH_INTERVAL", "wercker.watch_interval", true, func(c *Config) string { return c.Wercker.WatchInterval }},
+	{"WERCKER_WEBHOOK_SECRET", "wercker.webhook.secret", true, func(c *Config) string { return c.Wercker.Webhook.Secret }},
+	{"WERCKER_WEBHOOK_CHANNELS", "wercker.webhook.channels", false, func(c *Config) string { return joinPairs(c.Wercker.Webhook.Channels) }},
+	{"LOCKS_CHANNEL", "locks.channel", false, func(c *Config) string { return c.Locks.Channel }},
+	{"LOG_LEVEL", "log.level", true, func(c *Config) string { return c.Log.Level }},
+	{"HEALTH_MAX_SILENCE", "health.max_silence", true, func(c *Config) string { return c.Health.MaxSilence }},
+	{"AUDIT_RETENTION", "audit.retention", true, func(c *Config) string { return c.Audit.Retention }},
+}
+
+// loadedConfig is the configuration file flattened into settings
+type loadedConfig struct {
+	path string
+	// sum identifies the content of the file, to notice when it changes
+	sum        [sha256.Size]byte
+	global     map[string]string
+	workspaces map[string]map[string]string
+}
+
+var (
+	configMu sync.RWMutex
+	// the configuration Mario runs with, empty until loadConfig is called.
+	// a loadedConfig never changes, reloading swaps it for a new one
+	currentConfig = &loadedConfig{}
+)
+
+// config returns the configuration Mario runs with
+func config() *loadedConfig {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return currentConfig
+}
+
+// useConfig makes a configuration the one Mario runs with
+// Returns the previous configuration
+func useConfig(c *loadedConfig) *loadedConfig {
+	configMu.Lock()
+	defer configMu.Unlock()
+	previous := currentConfig
+	currentConfig = c
+	return previous
+}
+
+// settings flattens a configuration into settings named like their environment variables
+func (c *Config) settings() map[string]string {
+	values := map[string]string{}
+
+	for _, setting := range configSettings {
+		if value := setting.value(c); value != "" {
+			values[setting.name] = value
+		}
+	}
+
+	return values
+}
+
+// parseConfig reads a configuration file
+// Returns the configuration and an error naming the invalid fields
+func parseConfig(path string) (*loadedConfig, error) {
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var c Config
+
+	if err := yaml.UnmarshalStrict(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	loaded := &loadedConfig{path: path, sum: sha256.Sum256(data), global: c.settings(), workspaces: map[string]map[string]string{}}
+
+	for workspace, overrides := range c.Workspaces {
+		if overrides == nil {
+			continue
+		}
+
+		if len(overrides.Workspaces) > 0 {
+			return nil, fmt.Errorf("%s: workspaces.%s cannot have workspaces", path, workspace)
+		}
+
+		settings := overrides.settings()
+
+		for _, setting := range configSettings {
+			if _, ok := settings[setting.name]; ok && setting.global {
+				return nil, fmt.Errorf("%s: %s cannot be set per workspace, move it out of workspaces.%s", path, setting.path, workspace)
+			}
+		}
+
+		loaded.workspaces[workspace] = settings
+	}
+
+	return loaded, nil
+}
+
+// findConfig returns the path of the configuration file:
+// --config, or mario.yml if it exists
+// Returns an empty path if Mario runs without configuration file
+func findConfig() string {
+	if *configPath != "" {
+		return *configPath
+	}
+
+	if _, err := os.Stat("mario.yml"); err == nil {
+		return "mario.yml"
+	}
+
+	return ""
+}
+
+// readConfig reads the configuration file, if there is one
+// Returns an empty configuration if Mario runs without configuration file
+func readConfig() (*loadedConfig, error) {
+	if path := findConfig(); path != "" {
+		return parseConfig(path)
+	}
+
+	return &loadedConfig{}, nil
+}
+
+// loadConfig reads the configuration file and makes it Mario's configuration
+func loadConfig() error {
+	loaded, err := readConfig()
+
+	if err != nil {
+		return err
+	}
+
+	useConfig(loaded)
+	refreshSecrets()
+
+	return nil
+}
+
+// positionalSetting reads the settings passed as arguments by earlier versions of Mario:
+// mario <slack token> [wercker token]
+func positionalSetting(name string) string {
+	if flag.Arg(0) == "config" {
+		return ""
+	}
+
+	switch name {
+	case "TOKEN":
+		return flag.Arg(0)
+	case "WERCKER_TOKEN":
+		return flag.Arg(1)
+	}
+
+	return ""
+}
+
+// setting reads a setting, in order from
+// the environment variable suffixed with the workspace ID, e.g. WERCKER_TOKEN_T024BE7LD,
+// the workspace in the configuration file, the environment variable
+// and the configuration file: what is set for a workspace comes before what is set for all of them
+// Returns the value of the setting
+func (c *loadedConfig) setting(workspace, name string) string {
+	if workspace != "" {
+		if value := os.Getenv(name + "_" + workspace); value != "" {
+			return value
+		}
+	}
+
+	if value := c.workspaces[workspace][name]; value != "" {
+		return value
+	}
+
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+
+	if value := c.global[name]; value != "" {
+		return value
+	}
+
+	return positionalSetting(name)
+}
+
+// workspaceSetting reads a setting of the configuration Mario runs with
+// Returns the value of the setting
+func workspaceSetting(workspace, name string) string {
+	return config().setting(workspace, name)
+}
+
+// isAdmin checks whether a user administers Mario in a workspace
+// MARIO_ADMINS is a comma separated list of user IDs
+func isAdmin(workspace, user string) bool {
+	for _, admin := range strings.Split(workspaceSetting(workspace, "MARIO_ADMINS"), ",") {
+		if admin = strings.TrimSpace(admin); admin != "" && admin == user {
+			return true
+		}
+	}
+	return false
+}
+
+// settingName describes a setting in error messages,
+// with its place in the configuration file and its environment variable
+func settingName(name string) string {
+	for _, setting := range configSettings {
+		if setting.name == name {
+			return setting.path + " (" + name + ")"
+		}
+	}
+	return name
+}
+
+// checkPairs checks a setting is a comma separated list of key=value
+func checkPairs(c *loadedConfig, workspace, name, example string) error {
+	for _, pair := range strings.Split(c.setting(workspace, name), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		if parts := strings.SplitN(pair, "=", 2); len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return fmt.Errorf("%s must map keys to values, e.g. %s, got %q", settingName(name), example, pair)
+		}
+	}
+	return nil
+}
+
+// validateConfig checks the settings of a configuration
+// Returns every problem found
+func validateConfig(c *loadedConfig, adapter string) []error {
+	var errs []error
+
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if _, ok := adapters[adapter]; !ok {
+		check(fmt.Errorf("%s must be one of %s, got %q", settingName("ADAPTER"), adapterNames(), adapter))
+	}
+
+	required := map[string][]string{
+		"slack":      {"TOKEN"},
+		"irc":        {"IRC_SERVER"},
+		"mattermost": {"MATTERMOST_URL", "MATTERMOST_TOKEN"},
+	}
+
+	for _, name := range required[adapter] {
+		if c.setting("", name) == "" {
+			check(fmt.Errorf("%s is required by the %s adapter", settingName(name), adapter))
+		}
+	}
+
+	if value := c.setting("", "LOG_LEVEL"); value != "" {
+		if _, ok := parseLogLevel(value); !ok {
+			check(fmt.Errorf("%s must be debug, info, warn or error, got %q", settingName("LOG_LEVEL"), value))
+		}
+	}
+
+	if value := c.setting("", "IRC_TLS"); value != "" && value != "true" && value != "false" {
+		check(fmt.Errorf("%s must be true or false, got %q", settingName("IRC_TLS"), value))
+	}
+
+	if value := c.setting("", "WERCKER_WATCH_INTERVAL"); value != "" {
+		if interval, err := time.ParseDuration(value); err != nil || interval < time.Second {
+			check(fmt.Errorf("%s must be a duration of at least 1s such as 30s or 5m, got %q", settingName("WERCKER_WATCH_INTERVAL"), value))
+		}
+	}
+
+	if value := c.setting("", "HEALTH_MAX_SILENCE"); value != "" {
+		if silence, err := time.ParseDuration(value); err != nil || silence <= 0 {
+			check(fmt.Errorf("%s must be a duration such as 90s or 5m, got %q", settingName("HEALTH_MAX_SILENCE"), value))
+		}
+	}
+
+	if value := c.setting("", "AUDIT_RETENTION"); value != "" {
+		if retention, err := parseAge(value); err != nil || retention <= 0 {
+			check(fmt.Errorf("%s must be a duration such as 90d or 720h, got %q", settingName("AUDIT_RETENTION"), value))
+		}
+	}
+
+	if value := c.setting("", "BRAIN"); value != "" && value != "memory" &&
+		!strings.HasPrefix(value, "file:") && !strings.HasPrefix(value, "redis://") && !strings.HasPrefix(value, "rediss://") {
+		check(fmt.Errorf("%s must be memory, file:<path>, redis://<host>:<port> or rediss://<host>:<port>, got %q", settingName("BRAIN"), value))
+	}
+
+	if c.setting("", "WERCKER_WEBHOOK_SECRET") != "" && c.setting("", "HTTP_ADDR") == "" && c.setting("", "PORT") == "" {
+		check(fmt.Errorf("%s needs %s or PORT to receive the webhooks", settingName("WERCKER_WEBHOOK_SECRET"), settingName("HTTP_ADDR")))
+	}
+
+	if c.setting("", "ADMIN_API_TOKEN") != "" && c.setting("", "HTTP_ADDR") == "" && c.setting("", "PORT") == "" {
+		check(fmt.Errorf("%s needs %s or PORT to serve the admin API", settingName("ADMIN_API_TOKEN"), settingName("HTTP_ADDR")))
+	}
+
+	if c.setting("", "DASHBOARD_PASSWORD") != "" && c.setting("", "HTTP_ADDR") == "" && c.setting("", "PORT") == "" {
+		check(fmt.Errorf("%s needs %s or PORT to serve the dashboard", settingName("DASHBOARD_PASSWORD"), settingName("HTTP_ADDR")))
+	}
+
+	// the settings that can differ per workspace
+	workspaces := []string{""}
+
+	for workspace := range c.workspaces {
+		workspaces = append(workspaces, workspace)
+	}
+
+	sort.Strings(workspaces)
+
+	for _, workspace := range workspaces {
+		for _, name := range []string{"WERCKER_WATCH", "WERCKER_WEBHOOK_CHANNELS"} {
+			_, err := parseAppChannels(name, c.setting(workspace, name))
+			check(err)
+		}
+
+		check(checkPairs(c, workspace, "WERCKER_CHANNEL_ORGS", "C024BE91L=umbrellium"))
+		check(checkPairs(c, workspace, "WERCKER_USERS", "alice=U024BE7LH"))
+		check(checkPairs(c, workspace, "WERCKER_DEPLOYERS", "staging=*,production=U024BE7LH|U024BE7LJ"))
+	}
+
+	return errs
+}
+
+// checkConfig implements "mario config check": it reads and validates the configuration
+// Returns the exit status of Mario
+func checkConfig() int {
+	loaded, err := readConfig()
+
+	if err != nil {
+		fmt.Println("Invalid configuration:", err)
+		return 1
+	}
+
+	adapter := chosenAdapter(loaded)
+
+	errs := validateConfig(loaded, adapter)
+
+	for _, err := range errs {
+		fmt.Println("Invalid configuration:", err)
+	}
+
+	if len(errs) > 0 {
+		return 1
+	}
+
+	path := loaded.path
+	if path == "" {
+		path = "the environment"
+	}
+
+	fmt.Printf("The configuration in %s is valid for the %s adapter\n", path, adapter)
+	return 0
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testConfig = `
+adapter: slack
+admins: [U0ADMIN]
+slack:
+  tokens: [xoxb-one, xoxb-two]
+wercker:
+  token: wercker-token
+  org: umbrellium
+  deployers:
+    staging: ["*"]
+    production: [U0ONE, U0TWO]
+  watch:
+    umbrellium/mario: C0BUILDS
+workspaces:
+  T1:
+    wercker:
+      org: client
+    locks:
+      channel: C0LOCKS
+`
+
+// withConfigFile writes a configuration file and makes it Mario's configuration
+// Returns a function that restores the previous configuration and removes the file
+func withConfigFile(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "mario-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "mario.yml")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	previousPath, previous := *configPath, config()
+	*configPath = path
+
+	return path, func() {
+		*configPath = previousPath
+		useConfig(previous)
+		os.RemoveAll(dir)
+	}
+}
+
+// test reading the settings from the configuration file
+func TestConfig(t *testing.T) {
+	_, restore := withConfigFile(t, testConfig)
+	defer restore()
+
+	if err := loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("WERCKER_TOKEN_T2", "env-token")
+	defer os.Unsetenv("WERCKER_TOKEN_T2")
+
+	tests := []struct {
+		workspace, name, expected string
+	}{
+		{"", "TOKEN", "xoxb-one,xoxb-two"},
+		{"", "WERCKER_DEPLOYERS", "production=U0ONE|U0TWO,staging=*"},
+		{"", "WERCKER_WATCH", "umbrellium/mario=C0BUILDS"},
+		{"", "LOCKS_CHANNEL", ""},
+		// workspaces override the global settings
+		{"T1", "WERCKER_ORG", "client"},
+		{"T1", "LOCKS_CHANNEL", "C0LOCKS"},
+		{"T1", "WERCKER_TOKEN", "wercker-token"},
+		{"T2", "WERCKER_ORG", "umbrellium"},
+		// environment variables override the configuration file
+		{"T2", "WERCKER_TOKEN", "env-token"},
+	}
+
+	for _, test := range tests {
+		if value := workspaceSetting(test.workspace, test.name); value != test.expected {
+			t.Errorf("Expected %s in workspace %q to be %q, got %q", test.name, test.workspace, test.expected, value)
+		}
+	}
+
+	os.Setenv("WERCKER_ORG", "env-org")
+	defer os.Unsetenv("WERCKER_ORG")
+
+	if value := workspaceSetting("T2", "WERCKER_ORG"); value != "env-org" {
+		t.Errorf("Expected the environment to override the global settings, got %q", value)
+	}
+
+	if value := workspaceSetting("T1", "WERCKER_ORG"); value != "client" {
+		t.Errorf("Expected the workspace settings to override the environment, got %q", value)
+	}
+
+	if !isAdmin("T1", "U0ADMIN") || isAdmin("T1", "U0ONE") {
+		t.Error("Expected admins to be read from the configuration file")
+	}
+
+	if errs := validateConfig(config(), "slack"); len(errs) > 0 {
+		t.Errorf("Expected the configuration to be valid, got %v", errs)
+	}
+	os.Setenv("BRAIN", "rediss://:secret@localhost:6380?skip-verify")
+	defer os.Unsetenv("BRAIN")
+
+	if errs := validateConfig(config(), "slack"); len(errs) > 0 {
+		t.Errorf("Expected a Redis brain over TLS to be valid, got %v", errs)
+	}
+}
+
+// test configuration files that cannot be loaded
+func TestConfigErrors(t *testing.T) {
+	tests := []struct {
+		content, expected string
+	}{
+		{"slack:\n  token: xoxb-one\n", "field token not found"},
+		{"adapter: [slack]\n", "cannot unmarshal"},
+		{"workspaces:\n  T1:\n    brain: memory\n", "brain cannot be set per workspace"},
+		{"workspaces:\n  T1:\n    workspaces:\n      T2: {}\n", "workspaces.T1 cannot have workspaces"},
+	}
+
+	for _, test := range tests {
+		_, restore := withConfigFile(t, test.content)
+		err := loadConfig()
+		restore()
+
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("Expected loading %q to fail with %q, got %v", test.content, test.expected, err)
+		}
+	}
+}
+
+// test validating the settings
+func TestValidateConfig(t *testing.T) {
+	_, restore := withConfigFile(t, `
+adapter: irc
+brain: bolt:mario.db
+log:
+  level: loud
+health:
+  max_silence: forever
+wercker:
+  watch_interval: 10ms
+  watch:
+    mario: C0BUILDS
+  webhook:
+    secret: s3cret
+workspaces:
+  T1:
+    wercker:
+      users:
+        alice: ""
+`)
+	defer restore()
+
+	if err := loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := validateConfig(config(), "irc")
+
+	expected := []string{
+		"irc.server (IRC_SERVER) is required by the irc adapter",
+		"wercker.watch_interval (WERCKER_WATCH_INTERVAL) must be a duration",
+		"brain (BRAIN) must be memory",
+		"log.level (LOG_LEVEL) must be debug, info, warn or error",
+		"health.max_silence (HEALTH_MAX_SILENCE) must be a duration",
+		"wercker.webhook.secret (WERCKER_WEBHOOK_SECRET) needs http.addr (HTTP_ADDR)",
+		"wercker.watch (WERCKER_WATCH) entries must look like <organisation>/<app>=<channel ID>",
+		"wercker.users (WERCKER_USERS) must map keys to values",
+	}
+
+	var messages []string
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+
+	for _, message := range expected {
+		if !strings.Contains(strings.Join(messages, "\n"), message) {
+			t.Errorf("Expected an error %q, got %q", message, messages)
+		}
+	}
+
+	if errs := validateConfig(config(), "telegram"); len(errs) == 0 || !strings.Contains(errs[0].Error(), "adapter (ADAPTER) must be one of") {
+		t.Errorf("Expected an unknown adapter to be refused, got %v", errs)
+	}
+}
+
+// test mario config check
+func TestCheckConfig(t *testing.T) {
+	_, restore := withConfigFile(t, testConfig)
+	defer restore()
+
+	// the adapter of the configuration file
+	if status := checkConfig(); status != 0 {
+		t.Errorf("Expected the configuration to be valid, got status %d", status)
+	}
+
+	*adapterName = "mattermost"
+	defer func() { *adapterName = "" }()
+
+	if status := checkConfig(); status != 1 {
+		t.Errorf("Expected the mattermost settings to be missing, got status %d", status)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// conversation is a question Mario asked someone
+// the next message that person addresses to Mario in the same channel
+// is passed to answer, which returns false if it isn't an answer to the question
+type conversation struct {
+	Workspace string    `json:"workspace,omitempty"`
+	Channel   string    `json:"channel"`
+	User      string    `json:"user"`
+	Question  string    `json:"question"`
+	Started   time.Time `json:"started"`
+	Expires   time.Time `json:"expires"`
+	answer    func(chat chatAgent, message Message, input string) bool
+}
+
+// conversationList holds the conversations waiting for an answer
+type conversationList struct {
+	mu    sync.Mutex
+	items map[string]*conversation
+}
+
+// conversations that Mario is currently having
+var conversations = &conversationList{items: map[string]*conversation{}}
+
+// conversationKey identifies who Mario is talking to
+func conversationKey(workspace, channel, user string) string {
+	return workspace + "/" + channel + "/" + user
+}
+
+// ask starts a conversation with the author of a message
+// a new question replaces the previous one
+func (c *conversationList) ask(message Message, question string, timeout time.Duration, answer func(chat chatAgent, message Message, input string) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.items[conversationKey(message.Workspace, message.Channel, message.User)] = &conversation{
+		Workspace: message.Workspace,
+		Channel:   message.Channel,
+		User:      message.User,
+		Question:  question,
+		Started:   time.Now(),
+		Expires:   time.Now().Add(timeout),
+		answer:    answer,
+	}
+}
+
+// list returns the conversations waiting for an answer, the oldest first
+func (c *conversationList) list() []conversation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	var list []conversation
+
+	for _, conv := range c.items {
+		if now.Before(conv.Expires) {
+			list = append(list, *conv)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Started.Before(list[j].Started) })
+
+	return list
+}
+
+// answer passes a message to the conversation with its author
+// Returns true if the message answered the question, the conversation is then over
+func (c *conversationList) answer(chat chatAgent, message Message, input string) bool {
+	key := conversationKey(message.Workspace, message.Channel, message.User)
+
+	c.mu.Lock()
+	conv, ok := c.items[key]
+	if ok && time.Now().After(conv.Expires) {
+		delete(c.items, key)
+		ok = false
+	}
+	c.mu.Unlock()
+
+	if !ok || !conv.answer(chat, message, input) {
+		return false
+	}
+
+	c.mu.Lock()
+	if c.items[key] == conv {
+		delete(c.items, key)
+	}
+	c.mu.Unlock()
+
+	return true
+}
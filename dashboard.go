@@ -0,0 +1,472 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"html/template"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// commandEntry is a command Mario received, shown on the dashboard
+type commandEntry struct {
+	Time      time.Time
+	Workspace string
+	Channel   string
+	User      string
+	Text      string
+	// Task is the task that handled the command, empty if none did
+	Task     string
+	Duration time.Duration
+}
+
+// commandList keeps the last commands Mario received
+type commandList struct {
+	mu    sync.Mutex
+	items []commandEntry
+	size  int
+}
+
+// the last commands Mario received
+var recentCommands = &commandList{size: 50}
+
+// add remembers a command, forgetting the oldest one when the list is full
+// the text is redacted as it's shown to people who may not see the channel
+func (c *commandList) add(message Message, text, task string, duration time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.items = append(c.items, commandEntry{
+		Time:      time.Now(),
+		Workspace: message.Workspace,
+		Channel:   message.Channel,
+		User:      message.User,
+		Text:      redact(text),
+		Task:      task,
+		Duration:  duration,
+	})
+
+	if len(c.items) > c.size {
+		c.items = c.items[len(c.items)-c.size:]
+	}
+}
+
+// list returns the commands, the most recent first
+func (c *commandList) list() []commandEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	list := make([]commandEntry, len(c.items))
+	for i, entry := range c.items {
+		list[len(list)-1-i] = entry
+	}
+
+	return list
+}
+
+// the cookie that keeps the dashboard session
+const dashboardCookie = "mario_session"
+
+// how long a dashboard session lasts
+var sessionLifetime = 12 * time.Hour
+
+// how long Mario waits before answering a wrong password, to slow down guessing
+var loginDelay = time.Second
+
+// how many wrong passwords Mario accepts in loginWindow, from one address and from everyone,
+// before refusing to log anyone in until the window is over
+var (
+	loginWindow         = 15 * time.Minute
+	loginAttempts       = 5
+	loginGlobalAttempts = 50
+)
+
+// dashboardSession is a login to the dashboard,
+// it ends when the password changes
+type dashboardSession struct {
+	expires  time.Time
+	password [sha256.Size]byte
+}
+
+// dashboard shows what Mario can do and what he's been doing,
+// people log in with DASHBOARD_PASSWORD
+type dashboard struct {
+	chats   []chatAgent
+	adapter string
+
+	mu       sync.Mutex
+	sessions map[string]dashboardSession
+	// failures are the times of the wrong passwords in the last loginWindow, by address
+	failures map[string][]time.Time
+}
+
+// newDashboard creates the dashboard of the chat connections
+func newDashboard(chats []chatAgent, adapter string) *dashboard {
+	return &dashboard{chats: chats, adapter: adapter, sessions: map[string]dashboardSession{}, failures: map[string][]time.Time{}}
+}
+
+// remoteHost returns the address of a request without its port, on Heroku,
+// which sets DYNO, the address of the client the router appended to X-Forwarded-For
+func remoteHost(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" && workspaceSetting("", "DYNO") != "" {
+		addresses := strings.Split(forwarded, ",")
+		return strings.TrimSpace(addresses[len(addresses)-1])
+	}
+
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// loginBlocked tells whether too many wrong passwords were given,
+// from an address or from everyone, forgetting the ones older than loginWindow
+func (d *dashboard) loginBlocked(host string, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	total := 0
+
+	for address, times := range d.failures {
+		for len(times) > 0 && now.Sub(times[0]) > loginWindow {
+			times = times[1:]
+		}
+
+		if len(times) == 0 {
+			delete(d.failures, address)
+			continue
+		}
+
+		d.failures[address] = times
+		total += len(times)
+	}
+
+	return len(d.failures[host]) >= loginAttempts || total >= loginGlobalAttempts
+}
+
+// loginFailed remembers a wrong password
+func (d *dashboard) loginFailed(host string, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failures[host] = append(d.failures[host], now)
+}
+
+// handleDashboard serves the dashboard on /dashboard
+func handleDashboard(chats []chatAdapter, adapter string) {
+	d := newDashboard(agents(chats), adapter)
+
+	httpMux.HandleFunc("/dashboard", d.serveDashboard)
+	httpMux.HandleFunc("/dashboard/login", d.serveLogin)
+	httpMux.HandleFunc("/dashboard/logout", d.serveLogout)
+}
+
+// newSessionID returns a random session ID
+func newSessionID() string {
+	id := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// loggedIn checks the session of a request
+func (d *dashboard) loggedIn(r *http.Request, password string) bool {
+	cookie, err := r.Cookie(dashboardCookie)
+
+	if err != nil {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	session, ok := d.sessions[cookie.Value]
+
+	if !ok {
+		return false
+	}
+
+	if time.Now().After(session.expires) || session.password != sha256.Sum256([]byte(password)) {
+		delete(d.sessions, cookie.Value)
+		return false
+	}
+
+	return true
+}
+
+// login starts a session
+// Returns the session ID
+func (d *dashboard) login(password string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+
+	// forget the sessions that ended
+	for id, session := range d.sessions {
+		if now.After(session.expires) {
+			delete(d.sessions, id)
+		}
+	}
+
+	id := newSessionID()
+	d.sessions[id] = dashboardSession{expires: now.Add(sessionLifetime), password: sha256.Sum256([]byte(password))}
+
+	return id
+}
+
+// dashboardPassword returns DASHBOARD_PASSWORD, answering 404 if it isn't set
+// the password is read on every request, a reload can enable the dashboard or change the password
+func dashboardPassword(w http.ResponseWriter) (string, bool) {
+	password := workspaceSetting("", "DASHBOARD_PASSWORD")
+
+	if password == "" {
+		http.Error(w, "The dashboard is disabled, set "+settingName("DASHBOARD_PASSWORD")+" to enable it", http.StatusNotFound)
+		return "", false
+	}
+
+	return password, true
+}
+
+// serveLogin shows the login form and checks the password
+func (d *dashboard) serveLogin(w http.ResponseWriter, r *http.Request) {
+	password, ok := dashboardPassword(w)
+
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		renderPage(w, http.StatusOK, loginPage, nil)
+	case "POST":
+		given := r.PostFormValue("password")
+		host := remoteHost(r)
+
+		if d.loginBlocked(host, time.Now()) {
+			logs.warn("refused a dashboard login after too many wrong passwords", "remote", host)
+			w.Header().Set("Retry-After", strconv.Itoa(int(loginWindow.Seconds())))
+			renderPage(w, http.StatusTooManyRequests, loginPage, "Too many wrong passwords, try again later")
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(given), []byte(password)) != 1 {
+			logs.warn("refused a dashboard login", "remote", host)
+			d.loginFailed(host, time.Now())
+			time.Sleep(loginDelay)
+			renderPage(w, http.StatusUnauthorized, loginPage, "Wrong password")
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     dashboardCookie,
+			Value:    d.login(password),
+			Path:     "/dashboard",
+			MaxAge:   int(sessionLifetime.Seconds()),
+			HttpOnly: true,
+			// behind the router of Heroku, the connection is only secure up to the router
+			Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+			SameSite: http.SameSiteStrictMode,
+		})
+
+		logs.info("logged in the dashboard", "remote", host)
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Only GET and POST are allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveLogout ends the session
+func (d *dashboard) serveLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Only POST is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if cookie, err := r.Cookie(dashboardCookie); err == nil {
+		d.mu.Lock()
+		delete(d.sessions, cookie.Value)
+		d.mu.Unlock()
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: dashboardCookie, Path: "/dashboard", MaxAge: -1})
+	http.Redirect(w, r, "/dashboard/login", http.StatusSeeOther)
+}
+
+// dashboardTask is a task and its help
+type dashboardTask struct {
+	Name string
+	Help string
+}
+
+// dashboardView is what the dashboard shows
+type dashboardView struct {
+	Adapter       string
+	Ready         bool
+	Uptime        string
+	Connections   []string
+	Components    map[string]componentHealth
+	Tasks         []dashboardTask
+	Commands      []commandEntry
+	Jobs          []job
+	Locks         []deployLock
+	Conversations []conversation
+	Errors        []string
+}
+
+// view gathers what the dashboard shows
+func (d *dashboard) view() dashboardView {
+	ready, components := readiness(time.Now())
+
+	v := dashboardView{
+		Adapter:       d.adapter,
+		Ready:         ready,
+		Uptime:        time.Since(startedAt).Truncate(time.Second).String(),
+		Components:    components,
+		Commands:      recentCommands.list(),
+		Jobs:          jobs.list(),
+		Conversations: conversations.list(),
+	}
+
+	for i := range v.Jobs {
+		v.Jobs[i].LastError = redact(v.Jobs[i].LastError)
+	}
+
+	for name := range components {
+		v.Connections = append(v.Connections, name)
+	}
+	sort.Strings(v.Connections)
+
+	for _, task := range tasks {
+		v.Tasks = append(v.Tasks, dashboardTask{Name: task.getName(), Help: taskHelp(task)})
+	}
+
+	// the locks are kept per workspace
+	workspaces := map[string]bool{}
+
+	for _, chat := range d.chats {
+		workspace := workspaceOf(chat)
+
+		if workspaces[workspace] {
+			continue
+		}
+		workspaces[workspace] = true
+
+		current, err := locks.list(workspace)
+
+		if err != nil {
+			v.Errors = append(v.Errors, "Cannot read the deploy locks: "+redactError(err).Error())
+		}
+
+		v.Locks = append(v.Locks, current...)
+	}
+
+	return v
+}
+
+// serveDashboard shows the dashboard to the people logged in
+func (d *dashboard) serveDashboard(w http.ResponseWriter, r *http.Request) {
+	password, ok := dashboardPassword(w)
+
+	if !ok {
+		return
+	}
+
+	if !d.loggedIn(r, password) {
+		http.Redirect(w, r, "/dashboard/login", http.StatusSeeOther)
+		return
+	}
+
+	renderPage(w, http.StatusOK, dashboardPage, d.view())
+}
+
+// renderPage writes a page of the dashboard
+func renderPage(w http.ResponseWriter, code int, page *template.Template, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(code)
+
+	if err := page.Execute(w, data); err != nil {
+		logs.error("cannot render the dashboard", "err", err)
+	}
+}
+
+// the style shared by the pages
+const dashboardStyle = `<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 60em; color: #222; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 2em; }
+th, td { text-align: left; padding: .3em .6em; border-bottom: 1px solid #ddd; vertical-align: top; }
+pre { margin: 0; white-space: pre-wrap; }
+.up { color: #080; } .down { color: #c00; }
+</style>`
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Mario</title>` + dashboardStyle + `</head>
+<body>
+<h1>Mario</h1>
+{{if .}}<p class="down">{{.}}</p>{{end}}
+<form method="post" action="/dashboard/login">
+<label>Password <input type="password" name="password" autofocus></label>
+<button>Log in</button>
+</form>
+</body></html>
+`))
+
+var dashboardPage = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"since":      since,
+	"sinceEvent": func(t *time.Time) string { return since(*t) },
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Mario</title>` + dashboardStyle + `</head>
+<body>
+<form method="post" action="/dashboard/logout" style="float: right"><button>Log out</button></form>
+<h1>Mario</h1>
+<p>{{if .Ready}}<span class="up">Ready</span>{{else}}<span class="down">Not ready</span>{{end}}, running on {{.Adapter}} for {{.Uptime}}</p>
+{{range .Errors}}<p class="down">{{.}}</p>{{end}}
+
+<h2>Connections</h2>
+<table>
+<tr><th>Component</th><th>Status</th><th>Last event</th></tr>
+{{range .Connections}}{{$c := index $.Components .}}<tr><td>{{.}}</td><td class="{{$c.Status}}">{{$c.Status}}{{with $c.Detail}}: {{.}}{{end}}</td><td>{{with $c.LastEvent}}{{sinceEvent .}}{{end}}</td></tr>
+{{end}}</table>
+
+<h2>Commands</h2>
+<table>
+<tr><th>Task</th><th>Help</th></tr>
+{{range .Tasks}}<tr><td>{{.Name}}</td><td><pre>{{.Help}}</pre></td></tr>
+{{end}}</table>
+
+<h2>Recent commands</h2>
+{{if .Commands}}<table>
+<tr><th>When</th><th>User</th><th>Channel</th><th>Command</th><th>Task</th><th>Duration</th></tr>
+{{range .Commands}}<tr><td>{{since .Time}}</td><td>{{.User}}</td><td>{{.Channel}}</td><td>{{.Text}}</td><td>{{if .Task}}{{.Task}}{{else}}not understood{{end}}</td><td>{{.Duration}}</td></tr>
+{{end}}</table>{{else}}<p>No command since Mario started.</p>{{end}}
+
+<h2>Questions waiting for an answer</h2>
+{{if .Conversations}}<table>
+<tr><th>User</th><th>Channel</th><th>Question</th><th>Asked</th></tr>
+{{range .Conversations}}<tr><td>{{.User}}</td><td>{{.Channel}}</td><td>{{.Question}}</td><td>{{since .Started}}</td></tr>
+{{end}}</table>{{else}}<p>No question.</p>{{end}}
+
+<h2>Scheduled jobs</h2>
+<table>
+<tr><th>Job</th><th>Every</th><th>Last run</th><th>Last error</th></tr>
+{{range .Jobs}}<tr><td>{{.Name}}</td><td>{{.Interval}}</td><td>{{if .LastRun.IsZero}}not yet{{else}}{{since .LastRun}}{{end}}</td><td class="down">{{.LastError}}</td></tr>
+{{end}}</table>
+
+<h2>Deploy locks</h2>
+{{if .Locks}}<table>
+<tr><th>App</th><th>Locked by</th><th>Reason</th><th>Since</th><th>Until</th></tr>
+{{range .Locks}}<tr><td>{{.App}}</td><td>{{if .Deploy}}a deploy{{else}}{{.User}}{{end}}</td><td>{{.Reason}}</td><td>{{since .Created}}</td><td>{{if .Expires.IsZero}}unlocked{{else}}{{.Expires.Format "2006-01-02 15:04 MST"}}{{end}}</td></tr>
+{{end}}</table>{{else}}<p>No app is locked.</p>{{end}}
+</body></html>
+`))
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/umbrellium/mario/wercker"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// WerckerDeploy struct
+// deploys a build of an app to a Wercker deploy target
+type WerckerDeploy struct {
+}
+
+var (
+	// how often Mario checks the progress of a deploy
+	deployPollInterval = 10 * time.Second
+	// how long Mario follows a deploy before giving up
+	deployTimeout = time.Hour
+	// how long Mario waits for a deploy to be confirmed
+	deployConfirmTimeout = 5 * time.Minute
+)
+
+// userNames is implemented by the adapters that know the names of their users,
+// IRC messages already come from a nick
+type userNames interface {
+	userName(id string) string
+}
+
+// nameOf returns the name of a user, or the user ID if the adapter doesn't know it
+func nameOf(chat chatAgent, id string) string {
+	if names, ok := chat.(userNames); ok {
+		return names.userName(id)
+	}
+	return id
+}
+
+// Wercker build IDs are 24 hexadecimal characters,
+// anything else is a branch
+var buildIDPattern = regexp.MustCompile(`^[0-9a-f]{24}$`)
+
+// canDeploy checks whether the author of a message may deploy to a target
+// WERCKER_DEPLOYERS maps targets to the IDs of the users allowed to deploy there,
+// e.g. "staging=*,production=U024BE7LH|U024BE7LJ"
+func canDeploy(message Message, target string) bool {
+	for _, mapping := range strings.Split(workspaceSetting(message.Workspace, "WERCKER_DEPLOYERS"), ",") {
+		parts := strings.SplitN(mapping, "=", 2)
+
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) != target {
+			continue
+		}
+
+		for _, user := range strings.Split(parts[1], "|") {
+			if user = strings.TrimSpace(user); user == "*" || user == message.User {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (s WerckerDeploy) Hear(slack chatAgent, message Message, input string) bool {
+	patter, err := regexp.Compile(`^deploy(\s|$)`)
+
+	if err != nil {
+		taskLog(s, message).error("cannot parse the input", "err", err)
+	}
+
+	if !patter.MatchString(input) {
+		return false
+	}
+
+	options := strings.Fields(input)
+
+	if len(options) == 1 || options[1] == "help" {
+		err := s.Help(slack, message)
+		if err != nil {
+			taskLog(s, message).error("cannot post the help", "err", err)
+			return false
+		}
+		return true
+	}
+
+	if len(options) != 5 || options[3] != "to" {
+		message.Text = "Usage: @mario deploy <app> <branch|build id> to <target>"
+		slack.postMessage(message)
+		return true
+	}
+
+	err = s.prepare(slack, message, options[1], options[2], options[4])
+	if err != nil {
+		taskLog(s, message).error("cannot prepare the deploy", "err", err)
+	}
+	return true
+}
+
+// prepare finds the build to deploy and the target,
+// then asks the author of the message to confirm the deploy
+func (s WerckerDeploy) prepare(slack chatAgent, message Message, arg, ref, targetName string) error {
+	reply := message.inThread()
+
+	if !canDeploy(message, targetName) {
+		reply.Text = "Sorry, you are not allowed to deploy to " + targetName + "."
+		audit.complete(message, "refused", fmt.Errorf("not allowed to deploy to %s", targetName))
+		return slack.postMessage(reply)
+	}
+
+	org, app := werckerApp(message, arg)
+	client := werckerClient(message.Workspace)
+
+	held, err := locks.blocking(message.Workspace, org+"/"+app)
+
+	if err != nil {
+		reply.Text = "Sorry, I couldn't check whether " + org + "/" + app + " is locked: " + err.Error()
+		audit.complete(message, "failed", err)
+		slack.postMessage(reply)
+		return err
+	}
+
+	if held != nil {
+		reply.Text = "Sorry, " + formatLock(*held) + "."
+		audit.complete(message, "refused", errors.New(formatLock(*held)))
+		return slack.postMessage(reply)
+	}
+
+	build, err := s.resolveBuild(client, org, app, ref)
+
+	if err != nil {
+		reply.Text = "Sorry, I couldn't find a build of " + org + "/" + app + " to deploy: " + err.Error()
+		audit.complete(message, "failed", err)
+		slack.postMessage(reply)
+		return err
+	}
+
+	targets, err := client.Targets(org, app)
+
+	if err != nil {
+		reply.Text = "Sorry, I couldn't get the deploy targets of " + org + "/" + app + " from Wercker: " + err.Error()
+		audit.complete(message, "failed", err)
+		slack.postMessage(reply)
+		return err
+	}
+
+	var target *wercker.Target
+	var names []string
+
+	for i := range targets {
+		names = append(names, targets[i].Name)
+		if targets[i].Name == targetName {
+			target = &targets[i]
+		}
+	}
+
+	if target == nil {
+		reply.Text = fmt.Sprintf("%s/%s has no deploy target called %s. Its targets are: %s", org, app, targetName, strings.Join(names, ", "))
+		audit.complete(message, "failed", fmt.Errorf("no deploy target called %s", targetName))
+		return slack.postMessage(reply)
+	}
+
+	question := fmt.Sprintf("Deploy build `%s` of %s/%s (%s at `%s`: %s) to *%s*?\nReply `@mario yes` to deploy or `@mario no` to cancel.",
+		build.ID, org, app, build.Branch, shortCommit(build.CommitHash), strings.SplitN(build.Message, "\n", 2)[0], target.Name)
+
+	conversations.ask(message, question, deployConfirmTimeout, func(chat chatAgent, answer Message, input string) bool {
+		switch strings.ToLower(input) {
+		case "yes", "y":
+			// Mario follows the deploy until the lock expires
+			deadline := time.Now().Add(deployTimeout)
+
+			// nobody else deploys the app until this deploy is over
+			held, err := locks.lock(deployLock{
+				Workspace: message.Workspace,
+				App:       org + "/" + app,
+				User:      message.User,
+				Channel:   message.Channel,
+				Reason:    "deploying build `" + build.ID + "` to " + target.Name,
+				Expires:   deadline,
+				Deploy:    true,
+			})
+
+			if err != nil {
+				reply.Text = "Sorry, I couldn't lock " + org + "/" + app + " to deploy it: " + err.Error()
+				audit.complete(message, "failed", err)
+				chat.postMessage(reply)
+				return true
+			}
+
+			if held != nil {
+				reply.Text = "Sorry, " + formatLock(*held) + "."
+				audit.complete(message, "refused", errors.New(formatLock(*held)))
+				chat.postMessage(reply)
+				return true
+			}
+
+			go func() {
+				defer locks.release(message.Workspace, org+"/"+app)
+				s.deploy(chat, reply, client, org+"/"+app, *build, *target, deadline)
+			}()
+			return true
+		case "no", "n", "cancel":
+			reply.Text = "OK, I won't deploy " + org + "/" + app + "."
+			audit.complete(message, "cancelled", nil)
+			chat.postMessage(reply)
+			return true
+		}
+		return false
+	})
+
+	reply.Text = question
+	audit.complete(message, "confirming", nil)
+	return slack.postMessage(reply)
+}
+
+// resolveBuild finds a build by ID, or the latest passing build of a branch
+func (s WerckerDeploy) resolveBuild(client *wercker.Client, org, app, ref string) (*wercker.Build, error) {
+	if buildIDPattern.MatchString(ref) {
+		build, err := client.Build(ref)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if build.Status != "finished" || build.Result != "passed" {
+			return nil, fmt.Errorf("build %s is %s", ref, buildState(build.Status, build.Result))
+		}
+
+		return build, nil
+	}
+
+	opts := wercker.BuildOptions{Branch: ref, Status: "finished", Result: "passed"}
+	opts.Limit = 1
+
+	builds, err := client.Builds(org, app, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(builds) == 0 {
+		return nil, fmt.Errorf("there is no passing build of branch %s", ref)
+	}
+
+	return &builds[0], nil
+}
+
+// deploy triggers the deploy and follows it until it finishes,
+// posting its progress in the thread of the original message
+// and reporting how it ended in the audit record of the original message
+// Mario stops following the deploy at the deadline, when its lock expires
+func (s WerckerDeploy) deploy(slack chatAgent, reply Message, client *wercker.Client, app string, build wercker.Build, target wercker.Target, deadline time.Time) {
+	deploy, err := client.CreateDeploy(wercker.DeployRequest{
+		BuildID:  build.ID,
+		TargetID: target.ID,
+		Message:  "Deployed from chat by " + nameOf(slack, reply.User),
+	})
+
+	if err != nil {
+		reply.Text = "Sorry, Wercker refused to deploy " + app + ": " + err.Error()
+		audit.complete(reply, "failed", err)
+		slack.postMessage(reply)
+		return
+	}
+
+	record := deployRecord{
+		Time:      time.Now().UTC(),
+		Workspace: reply.Workspace,
+		Channel:   reply.Channel,
+		User:      reply.User,
+		App:       app,
+		Target:    target.Name,
+		DeployID:  deploy.ID,
+		BuildID:   build.ID,
+		Branch:    build.Branch,
+		Commit:    build.CommitHash,
+		Status:    "started",
+		Command:   reply.CorrelationID,
+	}
+	deployAudit.record(record)
+	audit.complete(reply, "deploying", nil)
+
+	reply.Text = fmt.Sprintf("Deploying build `%s` of %s to *%s*, deploy `%s`.", build.ID, app, target.Name, deploy.ID)
+	slack.postMessage(reply)
+
+	status := deploy.Status
+	failures := 0
+
+	for time.Now().Before(deadline) {
+		time.Sleep(deployPollInterval)
+
+		current, err := client.Deploy(deploy.ID)
+
+		if err != nil {
+			// give up if Wercker keeps failing
+			if failures++; failures == 5 {
+				record.Status = "abandoned"
+				deployAudit.record(record)
+
+				reply.Text = fmt.Sprintf("Sorry, I lost track of deploy `%s`: %v", deploy.ID, err)
+				audit.complete(reply, "failed", fmt.Errorf("lost track of deploy %s: %v", deploy.ID, err))
+				slack.postMessage(reply)
+				return
+			}
+			continue
+		}
+
+		failures = 0
+
+		if current.Status == "finished" {
+			record.Status, record.Result = "finished", current.Result
+			deployAudit.record(record)
+
+			if current.Result == "passed" {
+				audit.complete(reply, "deployed", nil)
+			} else {
+				audit.complete(reply, "failed", fmt.Errorf("deploy %s %s", deploy.ID, current.Result))
+			}
+
+			reply.Text = fmt.Sprintf("Deploy `%s` of %s to *%s* *%s*, %s.", deploy.ID, app, target.Name,
+				current.Result, buildDuration(current.StartedAt, current.FinishedAt))
+			slack.postMessage(reply)
+			return
+		}
+
+		if current.Status != status {
+			status = current.Status
+			reply.Text = fmt.Sprintf("Deploy `%s` is %s.", deploy.ID, status)
+			slack.postMessage(reply)
+		}
+	}
+
+	record.Status = "abandoned"
+	deployAudit.record(record)
+
+	reply.Text = fmt.Sprintf("Deploy `%s` still hasn't finished after %s, check it on Wercker.", deploy.ID, deployTimeout)
+	audit.complete(reply, "unknown", fmt.Errorf("deploy %s still hasn't finished after %s", deploy.ID, deployTimeout))
+	slack.postMessage(reply)
+}
+
+func (s WerckerDeploy) Help(slack chatAgent, message Message) error {
+	message.Text = `<deploy> deploys an app to one of its Wercker deploy targets.
+Usage:
+- @mario deploy <app> <branch> to <target>
+- @mario deploy <app> <build id> to <target>
+
+With a branch, I deploy its latest passing build.
+I ask you to confirm before deploying and post the progress of the deploy in the thread.
+`
+
+	err := slack.postMessage(message)
+
+	if err != nil {
+		taskLog(s, message).error("cannot post the help", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s WerckerDeploy) getName() string {
+	return "deploy"
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/umbrellium/mario/brain"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// pinger is implemented by the adapters that ping their chat service,
+// the replies are events that show the connection is alive
+type pinger interface {
+	ping() error
+}
+
+// how often Mario pings the chat services
+var pingInterval = 30 * time.Second
+
+// connectionState is what Mario knows about a chat connection
+type connectionState struct {
+	adapter     string
+	connected   bool
+	connectedAt time.Time
+	lastEvent   time.Time
+	err         string
+	// pings is true if the service is pinged, a silent connection is then a dead one
+	pings bool
+}
+
+// componentHealth is the health of a component in /readyz
+type componentHealth struct {
+	Status      string     `json:"status"`
+	Detail      string     `json:"detail,omitempty"`
+	ConnectedAt *time.Time `json:"connected_at,omitempty"`
+	LastEvent   *time.Time `json:"last_event,omitempty"`
+}
+
+// healthChecks tracks the chat connections
+type healthChecks struct {
+	mu          sync.Mutex
+	connections map[chatAdapter]*connectionState
+}
+
+var health = &healthChecks{connections: map[chatAdapter]*connectionState{}}
+
+// connectionName names a chat connection in /readyz, e.g. "slack/T024BE7LD"
+func connectionName(adapter string, chat chatAdapter) string {
+	if workspace := workspaceOf(chat); workspace != "" {
+		return adapter + "/" + workspace
+	}
+	return adapter
+}
+
+// connecting adds a chat connection that isn't connected yet
+func (h *healthChecks) connecting(adapter string, chat chatAdapter) {
+	_, pings := chat.(pinger)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.connections[chat] = &connectionState{adapter: adapter, pings: pings}
+}
+
+// connected marks a connection as open
+func (h *healthChecks) connected(chat chatAdapter) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if state, ok := h.connections[chat]; ok {
+		now := time.Now()
+		state.connected, state.connectedAt, state.lastEvent, state.err = true, now, now, ""
+	}
+}
+
+// event remembers when a connection last received an event
+func (h *healthChecks) event(chat chatAdapter) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if state, ok := h.connections[chat]; ok {
+		state.lastEvent = time.Now()
+	}
+}
+
+// disconnected marks a connection as lost
+func (h *healthChecks) disconnected(chat chatAdapter, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if state, ok := h.connections[chat]; ok {
+		state.connected = false
+		if err != nil {
+			state.err = err.Error()
+		}
+	}
+}
+
+// maxSilence is how long a pinged connection can go without events,
+// HEALTH_MAX_SILENCE or 2 minutes by default
+func maxSilence() time.Duration {
+	if silence, err := time.ParseDuration(workspaceSetting("", "HEALTH_MAX_SILENCE")); err == nil && silence > 0 {
+		return silence
+	}
+	return 2 * time.Minute
+}
+
+// connectionHealth checks every chat connection
+// Returns the health of each connection
+func (h *healthChecks) connectionHealth(now time.Time) map[string]componentHealth {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	components := map[string]componentHealth{}
+	silence := maxSilence()
+
+	for chat, state := range h.connections {
+		// the Slack workspaces are only known once connected
+		name := connectionName(state.adapter, chat)
+		for i := 2; components[name].Status != ""; i++ {
+			name = fmt.Sprintf("%s#%d", connectionName(state.adapter, chat), i)
+		}
+
+		component := componentHealth{Status: "up"}
+
+		if !state.connectedAt.IsZero() {
+			connectedAt, lastEvent := state.connectedAt, state.lastEvent
+			component.ConnectedAt, component.LastEvent = &connectedAt, &lastEvent
+		}
+
+		switch {
+		case !state.connected && state.err != "":
+			component.Status, component.Detail = "down", "disconnected: "+redact(state.err)
+		case !state.connected:
+			component.Status, component.Detail = "down", "not connected yet"
+		case state.pings && now.Sub(state.lastEvent) > silence:
+			component.Status = "down"
+			component.Detail = fmt.Sprintf("no event received for %s", now.Sub(state.lastEvent).Truncate(time.Second))
+		}
+
+		components[name] = component
+	}
+
+	return components
+}
+
+// storageHealth checks Mario can read his brain
+func storageHealth() componentHealth {
+	if _, err := brainStore.Get("health:check"); err != nil && err != brain.ErrNotFound {
+		return componentHealth{Status: "down", Detail: redact(err.Error())}
+	}
+
+	return componentHealth{Status: "up"}
+}
+
+// readiness checks every component
+// Returns true if Mario is ready and the health of each component
+func readiness(now time.Time) (bool, map[string]componentHealth) {
+	components := health.connectionHealth(now)
+	components["brain"] = storageHealth()
+
+	// Mario isn't ready until he has a chat connection
+	ready := len(components) > 1
+	for _, component := range components {
+		if component.Status != "up" {
+			ready = false
+		}
+	}
+
+	return ready, components
+}
+
+// serveHealthz answers as long as Mario's process runs
+func serveHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "alive"})
+}
+
+// serveReadyz tells whether Mario is connected and can work,
+// with the health of each component
+func serveReadyz(w http.ResponseWriter, r *http.Request) {
+	ready, components := readiness(time.Now())
+
+	status := "ready"
+	if !ready {
+		status = "not ready"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": status, "components": components})
+}
+
+func init() {
+	httpMux.HandleFunc("/healthz", serveHealthz)
+	httpMux.HandleFunc("/readyz", serveReadyz)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/umbrellium/mario/brain"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pingingShell is a chat adapter that pings its service
+type pingingShell struct {
+	*Shell
+}
+
+func (p pingingShell) ping() error {
+	return nil
+}
+
+// droppingShell is a shell whose connection drops before it reads anything,
+// then fails to reconnect once
+type droppingShell struct {
+	*Shell
+	connects int
+}
+
+func (d *droppingShell) connect() error {
+	if d.connects++; d.connects == 2 {
+		return fmt.Errorf("connection refused")
+	}
+	return d.Shell.connect()
+}
+
+func (d *droppingShell) getMessage() (Message, error) {
+	if d.connects == 1 {
+		return Message{}, fmt.Errorf("connection reset")
+	}
+	return d.Shell.getMessage()
+}
+
+// failingBrain is a brain that cannot be read
+type failingBrain struct {
+	brain.Store
+}
+
+func (failingBrain) Get(key string) ([]byte, error) {
+	return nil, fmt.Errorf("connection refused")
+}
+
+// useHealth gives Mario empty health checks
+// Returns a function that restores the previous ones
+func useHealth() func() {
+	previous := health
+	health = &healthChecks{connections: map[chatAdapter]*connectionState{}}
+
+	return func() {
+		health = previous
+	}
+}
+
+// test the readiness of the chat connections and the brain
+func TestReadiness(t *testing.T) {
+	defer useHealth()()
+	defer useBrain(t)()
+
+	now := time.Now()
+
+	if ready, _ := readiness(now); ready {
+		t.Error("Expected Mario not to be ready without chat connection")
+	}
+
+	chat := pingingShell{&Shell{Out: &bytes.Buffer{}}}
+	health.connecting("shell", chat)
+
+	tests := []struct {
+		update func()
+		at     time.Duration
+		status string
+		detail string
+	}{
+		{func() {}, 0, "down", "not connected yet"},
+		{func() { health.connected(chat) }, time.Minute, "up", ""},
+		// pinged connections must receive events
+		{func() {}, 3 * time.Minute, "down", "no event received for 3m0s"},
+		{func() { health.event(chat) }, time.Minute, "up", ""},
+		{func() { health.disconnected(chat, fmt.Errorf("EOF")) }, 0, "down", "disconnected: EOF"},
+	}
+
+	for i, test := range tests {
+		test.update()
+		ready, components := readiness(time.Now().Add(test.at))
+		shell := components["shell"]
+
+		if shell.Status != test.status || shell.Detail != test.detail || ready != (test.status == "up") {
+			t.Errorf("%d: expected the shell to be %s (%q), got %+v, ready %v", i, test.status, test.detail, shell, ready)
+		}
+
+		if components["brain"].Status != "up" {
+			t.Errorf("%d: expected the brain to be up, got %+v", i, components["brain"])
+		}
+	}
+
+	health.connected(chat)
+	brainStore = failingBrain{brainStore}
+
+	if ready, components := readiness(now); ready || components["brain"].Detail != "connection refused" {
+		t.Errorf("Expected an unreachable brain to make Mario not ready, got %+v", components["brain"])
+	}
+}
+
+// test the health endpoints
+func TestHealthEndpoints(t *testing.T) {
+	defer useHealth()()
+	defer useBrain(t)()
+
+	server := httptest.NewServer(httpMux)
+	defer server.Close()
+
+	chat := &Shell{Out: &bytes.Buffer{}}
+	health.connecting("shell", chat)
+
+	var body struct {
+		Status     string
+		Components map[string]componentHealth
+	}
+
+	check := func(path string, code int, status string) {
+		res, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		body.Status = ""
+		if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+
+		if res.StatusCode != code || body.Status != status {
+			t.Errorf("Expected %s to answer %d %q, got %d %q", path, code, status, res.StatusCode, body.Status)
+		}
+	}
+
+	check("/healthz", http.StatusOK, "alive")
+	check("/readyz", http.StatusServiceUnavailable, "not ready")
+
+	health.connected(chat)
+	check("/readyz", http.StatusOK, "ready")
+
+	if body.Components["shell"].ConnectedAt == nil || body.Components["brain"].Status != "up" {
+		t.Errorf("Expected the health of each component, got %+v", body.Components)
+	}
+}
+
+// test a lost connection is reconnected
+func TestKeepRunning(t *testing.T) {
+	defer useHealth()()
+
+	previousDelay := reconnectDelay
+	reconnectDelay = time.Millisecond
+	defer func() { reconnectDelay = previousDelay }()
+
+	out := &bytes.Buffer{}
+	chat := &droppingShell{Shell: &Shell{In: strings.NewReader("hello\n"), Out: out, User: "developer", Channel: "shell"}}
+	health.connecting("shell", chat)
+	chat.connect()
+
+	if err := keepRunning("shell", chat); err != io.EOF {
+		t.Errorf("Expected to run until the end of the input, got %v", err)
+	}
+
+	if chat.connects != 3 || !strings.Contains(out.String(), "Yo!") {
+		t.Errorf("Expected Mario to reconnect and answer, got %d connections and %q", chat.connects, out.String())
+	}
+}
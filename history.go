@@ -0,0 +1,397 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/umbrellium/mario/brain"
+	"github.com/umbrellium/mario/wercker"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DeployHistory struct
+// tells what is deployed where, combining the deploys on Wercker
+// with the deploys Mario triggered
+type DeployHistory struct {
+}
+
+// deployRecord is a deploy Mario triggered, kept in the brain
+// a deploy is recorded when Mario triggers it and again when it finishes
+type deployRecord struct {
+	Time      time.Time `json:"time"`
+	Workspace string    `json:"workspace,omitempty"`
+	Channel   string    `json:"channel"`
+	User      string    `json:"user"`
+	App       string    `json:"app"`
+	Target    string    `json:"target"`
+	DeployID  string    `json:"deploy"`
+	BuildID   string    `json:"build"`
+	Branch    string    `json:"branch,omitempty"`
+	Commit    string    `json:"commit,omitempty"`
+	// Status is "started", "finished", or "abandoned" once Mario stopped following the deploy,
+	// Result is only set once the deploy finished
+	Status string `json:"status"`
+	Result string `json:"result,omitempty"`
+	// Command is the correlation ID of the deploy command, its audit record tells how the deploy ended
+	Command string `json:"command,omitempty"`
+}
+
+// deployLog records the deploys Mario triggers in the brain,
+// each record expires after AUDIT_RETENTION
+type deployLog struct {
+}
+
+// deployAudit is Mario's deploy audit log
+var deployAudit = &deployLog{}
+
+// store returns the part of the brain where the deploys are kept
+func (l *deployLog) store() brain.Store {
+	return taskBrain(DeployHistory{})
+}
+
+// deployKey groups the records by app, e.g. T024BE7LD/umbrellium/mario/<deploy ID>
+func deployKey(workspace, app, id string) string {
+	return workspace + "/" + app + "/" + id
+}
+
+// record stores a record, replacing the previous record of the deploy
+func (l *deployLog) record(r deployRecord) {
+	value, err := json.Marshal(r)
+
+	if err == nil {
+		err = l.store().Set(deployKey(r.Workspace, r.App, r.DeployID), value, auditRetention())
+	}
+
+	if err != nil {
+		logs.error("cannot record deploy", "deploy", r.DeployID, "err", err)
+	}
+}
+
+// deploys reads the records of an app in a workspace
+// Returns the records keyed by deploy ID
+func (l *deployLog) deploys(workspace, app string) (map[string]deployRecord, error) {
+	store := l.store()
+	keys, err := store.List(deployKey(workspace, app, ""))
+
+	if err != nil {
+		return nil, err
+	}
+
+	records := map[string]deployRecord{}
+
+	for _, key := range keys {
+		value, err := store.Get(key)
+
+		// the record expired since the keys were listed
+		if err == brain.ErrNotFound {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		var r deployRecord
+
+		if err := json.Unmarshal(value, &r); err != nil {
+			return nil, fmt.Errorf("invalid deploy record %s: %v", key, err)
+		}
+
+		records[r.DeployID] = r
+	}
+
+	return records, nil
+}
+
+// deployEntry is a deploy of an app, from Wercker, Mario's log or both
+type deployEntry struct {
+	ID      string
+	Time    time.Time
+	Target  string
+	BuildID string
+	Branch  string
+	Commit  string
+	Status  string
+	Result  string
+	By      string
+}
+
+// deployHistory combines the recent deploys of an app on Wercker
+// with those in Mario's deploy audit log
+// Returns the deploys, the most recent first
+func deployHistory(workspace, org, app string) ([]deployEntry, error) {
+	opts := wercker.DeployOptions{}
+	opts.Limit = 50
+
+	deploys, err := werckerClient(workspace).Deploys(org, app, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := deployAudit.deploys(workspace, org+"/"+app)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []deployEntry
+
+	for _, d := range deploys {
+		entry := deployEntry{ID: d.ID, Time: d.CreatedAt, Status: d.Status, Result: d.Result, By: d.User.Username()}
+
+		if entry.Time.IsZero() {
+			entry.Time = d.StartedAt
+		}
+
+		if d.Target != nil {
+			entry.Target = d.Target.Name
+		}
+
+		if d.Build != nil {
+			entry.BuildID, entry.Branch, entry.Commit = d.Build.ID, d.Build.Branch, d.Build.CommitHash
+		}
+
+		if r, ok := records[d.ID]; ok {
+			entry.By = "<@" + r.User + "> via Mario"
+			if entry.Target == "" {
+				entry.Target = r.Target
+			}
+			if entry.BuildID == "" {
+				entry.BuildID, entry.Branch, entry.Commit = r.BuildID, r.Branch, r.Commit
+			}
+			delete(records, d.ID)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	// deploys that Wercker no longer lists, those Mario stopped following,
+	// or that should have finished long ago, e.g. Mario restarted, may never finish
+	for _, r := range records {
+		status := "running"
+		switch {
+		case r.Status == "finished":
+			status = "finished"
+		case r.Status == "abandoned" || time.Since(r.Time) > deployTimeout:
+			status = "unknown"
+		}
+
+		entries = append(entries, deployEntry{ID: r.DeployID, Time: r.Time, Target: r.Target, BuildID: r.BuildID,
+			Branch: r.Branch, Commit: r.Commit, Status: status, Result: r.Result, By: "<@" + r.User + "> via Mario"})
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.After(entries[j].Time) })
+
+	return entries, nil
+}
+
+// since tells how long ago something happened, e.g. "3h ago"
+func since(t time.Time) string {
+	d := time.Since(t)
+
+	switch {
+	case t.IsZero():
+		return "at an unknown time"
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	}
+
+	return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+}
+
+// formatDeployEntry describes a deploy on a single line
+func formatDeployEntry(entry deployEntry) string {
+	text := fmt.Sprintf("build `%s`", entry.BuildID)
+
+	if entry.Branch != "" {
+		text += " of " + entry.Branch
+	}
+
+	if entry.Commit != "" {
+		text += fmt.Sprintf(" at `%s`", shortCommit(entry.Commit))
+	}
+
+	by := entry.By
+	if by == "" {
+		by = "unknown"
+	}
+
+	text += fmt.Sprintf(", deployed by %s %s", by, since(entry.Time))
+
+	if !entry.Time.IsZero() {
+		text += " (" + entry.Time.Format("2 Jan 15:04 MST") + ")"
+	}
+
+	return text
+}
+
+func (s DeployHistory) Hear(slack chatAgent, message Message, input string) bool {
+	patter, err := regexp.Compile(`^(whats-deployed|deploy-history)(\s|$)`)
+
+	if err != nil {
+		taskLog(s, message).error("cannot parse the input", "err", err)
+	}
+
+	if !patter.MatchString(input) {
+		return false
+	}
+
+	options := strings.Fields(input)
+
+	if len(options) == 1 || options[1] == "help" {
+		err := s.Help(slack, message)
+		if err != nil {
+			taskLog(s, message).error("cannot post the help", "err", err)
+			return false
+		}
+		return true
+	}
+
+	args, flags, err := parseFlags(options[1:])
+
+	switch {
+	case err == nil && options[0] == "whats-deployed" && len(args) <= 2 && len(flags) == 0:
+		target := ""
+		if len(args) == 2 {
+			target = args[1]
+		}
+		err = s.whatsDeployed(slack, message, args[0], target)
+
+	case err == nil && options[0] == "deploy-history" && len(args) == 1:
+		err = s.history(slack, message, args[0], flags)
+
+	default:
+		message.Text = "Usage: @mario whats-deployed <app> [target] or @mario deploy-history <app> [--limit <number>]"
+		err = slack.postMessage(message)
+	}
+
+	if err != nil {
+		taskLog(s, message).error("cannot show the deploy history", "err", err)
+	}
+	return true
+}
+
+// whatsDeployed posts the build that was last deployed successfully to each target
+func (s DeployHistory) whatsDeployed(slack chatAgent, message Message, arg, target string) error {
+	org, app := werckerApp(message, arg)
+	entries, err := deployHistory(message.Workspace, org, app)
+
+	if err != nil {
+		message.Text = "Sorry, I couldn't get the deploys of " + org + "/" + app + ": " + err.Error()
+		slack.postMessage(message)
+		return err
+	}
+
+	live := map[string]deployEntry{}
+	var targets []string
+
+	for _, entry := range entries {
+		if entry.Result != "passed" || (target != "" && entry.Target != target) {
+			continue
+		}
+
+		if _, ok := live[entry.Target]; !ok {
+			live[entry.Target] = entry
+			targets = append(targets, entry.Target)
+		}
+	}
+
+	if len(targets) == 0 {
+		message.Text = "I don't know of any successful deploy of " + org + "/" + app
+		if target != "" {
+			message.Text += " to " + target
+		}
+		message.Text += "."
+		return slack.postMessage(message)
+	}
+
+	sort.Strings(targets)
+	message.Text = "Deployed " + org + "/" + app + ":\n"
+
+	for _, name := range targets {
+		if name == "" {
+			message.Text += "- unknown target: " + formatDeployEntry(live[name]) + "\n"
+			continue
+		}
+		message.Text += "- *" + name + "*: " + formatDeployEntry(live[name]) + "\n"
+	}
+
+	return slack.postMessage(message)
+}
+
+// history posts the recent deploys of an app
+func (s DeployHistory) history(slack chatAgent, message Message, arg string, flags map[string]string) error {
+	org, app := werckerApp(message, arg)
+	limit := 10
+
+	if value, ok := flags["limit"]; ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 50 {
+			message.Text = "The limit must be a number between 1 and 50."
+			return slack.postMessage(message)
+		}
+		limit = n
+	}
+
+	entries, err := deployHistory(message.Workspace, org, app)
+
+	if err != nil {
+		message.Text = "Sorry, I couldn't get the deploys of " + org + "/" + app + ": " + err.Error()
+		slack.postMessage(message)
+		return err
+	}
+
+	if len(entries) == 0 {
+		message.Text = org + "/" + app + " has never been deployed."
+		return slack.postMessage(message)
+	}
+
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	message.Text = fmt.Sprintf("Recent deploys of %s, the last one was %s:\n", org+"/"+app, since(entries[0].Time))
+
+	for _, entry := range entries {
+		target := entry.Target
+		if target == "" {
+			target = "unknown target"
+		}
+
+		message.Text += fmt.Sprintf("- *%s* to %s: %s\n", buildState(entry.Status, entry.Result), target, formatDeployEntry(entry))
+	}
+
+	return slack.postMessage(message)
+}
+
+func (s DeployHistory) Help(slack chatAgent, message Message) error {
+	message.Text = `<whats-deployed> tells which build of an app is live on each deploy target,
+<deploy-history> lists the recent deploys of an app.
+Usage:
+- @mario whats-deployed <app> [target]
+- @mario deploy-history <app> [--limit <number>]
+
+Deploys triggered from chat show who asked me to deploy.
+`
+
+	err := slack.postMessage(message)
+
+	if err != nil {
+		taskLog(s, message).error("cannot post the help", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s DeployHistory) getName() string {
+	return "deploy-history"
+}
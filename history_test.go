@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeWerckerHistory serves the deploys of umbrellium/mario,
+// d3 was deployed an hour ago, d2 two days ago and d1 a week ago
+func fakeWerckerHistory(w http.ResponseWriter, r *http.Request) {
+	at := func(ago time.Duration) string {
+		return time.Now().Add(-ago).UTC().Format(time.RFC3339)
+	}
+
+	switch r.URL.Path {
+	case "/applications/umbrellium/mario/deploys":
+		fmt.Fprintf(w, `[
+			{"id":"d3","status":"finished","result":"failed","createdAt":%q,"deployTarget":{"name":"production"},"build":{"id":"b3","branch":"master","commitHash":"cccccccccccc"},"user":{"meta":{"username":"carol"}}},
+			{"id":"d2","status":"finished","result":"passed","createdAt":%q,"deployTarget":{"name":"production"},"build":{"id":"b2","branch":"master","commitHash":"bbbbbbbbbbbb"},"user":{"meta":{"username":"mario-bot"}}},
+			{"id":"d1","status":"finished","result":"passed","createdAt":%q,"deployTarget":{"name":"staging"},"build":{"id":"b1","branch":"feature","commitHash":"aaaaaaaaaaaa"},"user":{"meta":{"username":"bob"}}}
+		]`, at(time.Hour), at(49*time.Hour), at(7*24*time.Hour))
+	case "/applications/umbrellium/luigi/deploys":
+		w.Write([]byte(`[]`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"statusCode":404,"message":"Not found"}`))
+	}
+}
+
+// test the <whats-deployed> and <deploy-history> commands
+func TestDeployHistory(t *testing.T) {
+	defer useFakeWercker(t, fakeWerckerHistory)()
+	defer useBrain(t)()
+
+	// d2 was triggered from chat, d4 is running and Wercker doesn't list it yet
+	deployAudit.record(deployRecord{Time: time.Now(), Channel: "C1", User: "Ualice", App: "umbrellium/mario", Target: "production", DeployID: "d2", BuildID: "b2", Status: "started"})
+	deployAudit.record(deployRecord{Time: time.Now(), Channel: "C1", User: "Ualice", App: "umbrellium/mario", Target: "production", DeployID: "d2", BuildID: "b2", Status: "finished", Result: "passed"})
+	deployAudit.record(deployRecord{Time: time.Now(), Channel: "C1", User: "Ubob", App: "umbrellium/mario", Target: "staging", DeployID: "d4", BuildID: "b4", Branch: "master", Status: "started"})
+	// Mario stopped following d6, and restarted while following d7
+	deployAudit.record(deployRecord{Time: time.Now().Add(-2 * time.Hour), Channel: "C1", User: "Ubob", App: "umbrellium/mario", Target: "staging", DeployID: "d6", BuildID: "b6", Status: "abandoned"})
+	deployAudit.record(deployRecord{Time: time.Now().Add(-3 * time.Hour), Channel: "C1", User: "Ubob", App: "umbrellium/mario", Target: "staging", DeployID: "d7", BuildID: "b7", Status: "started"})
+	deployAudit.record(deployRecord{Time: time.Now(), Channel: "C1", User: "Ubob", App: "umbrellium/luigi", Target: "staging", DeployID: "d5", BuildID: "b5", Status: "started", Workspace: "T2"})
+
+	history := DeployHistory{}
+	var chat FakeSlackChat
+
+	var tests = []struct {
+		input    string
+		expected []string
+	}{
+		{"whats-deployed mario", []string{"Deployed umbrellium/mario:\n",
+			"- *production*: build `b2` of master at `bbbbbbb`, deployed by <@Ualice> via Mario 2d ago",
+			"- *staging*: build `b1` of feature at `aaaaaaa`, deployed by bob 7d ago"}},
+		{"whats-deployed mario staging", []string{"- *staging*: build `b1`"}},
+		{"whats-deployed mario qa", []string{"I don't know of any successful deploy of umbrellium/mario to qa."}},
+		{"deploy-history mario", []string{"Recent deploys of umbrellium/mario, the last one was just now:\n",
+			"- *running* to staging: build `b4` of master, deployed by <@Ubob> via Mario just now",
+			"- *failed* to production: build `b3` of master at `ccccccc`, deployed by carol 1h ago",
+			"- *passed* to production: build `b2`",
+			"- *unknown* to staging: build `b6`",
+			"- *unknown* to staging: build `b7`"}},
+		{"deploy-history mario --limit 1", []string{"- *running* to staging"}},
+		{"deploy-history luigi", []string{"umbrellium/luigi has never been deployed."}},
+		{"deploy-history mario --limit 0", []string{"The limit must be a number between 1 and 50."}},
+		{"whats-deployed ghost", []string{"Sorry, I couldn't get the deploys of umbrellium/ghost"}},
+		{"whats-deployed help", []string{"<whats-deployed> tells which build"}},
+	}
+
+	for _, tst := range tests {
+		if !history.Hear(&chat, msg, tst.input) {
+			t.Fatalf("Expected %q to be handled", tst.input)
+		}
+
+		replies := chat.replies()
+		if len(replies) != 1 {
+			t.Fatalf("Expected %q to post a reply, got %+v", tst.input, replies)
+		}
+
+		for _, expected := range tst.expected {
+			if !strings.Contains(replies[0].Text, expected) {
+				t.Errorf("Expected %q to reply %q, got %q", tst.input, expected, replies[0].Text)
+			}
+		}
+	}
+
+	history.Hear(&chat, msg, "deploy-history mario --limit 1")
+	if replies := chat.replies(); len(replies) != 1 || strings.Contains(replies[0].Text, "production") {
+		t.Errorf("Expected the history to be limited to a deploy, got %+v", replies)
+	}
+
+	if history.Hear(&chat, msg, "deploy mario") || (WerckerDeploy{}).Hear(&chat, msg, "deploy-history mario") {
+		t.Errorf("Expected deploy and deploy-history not to be confused")
+	}
+}
+
+// test the deploy records expire with the audit log
+func TestDeployLogRetention(t *testing.T) {
+	defer useBrain(t)()
+
+	os.Setenv("AUDIT_RETENTION", "10ms")
+	defer os.Unsetenv("AUDIT_RETENTION")
+
+	deployAudit.record(deployRecord{Time: time.Now(), User: "Ualice", App: "umbrellium/mario", DeployID: "d2", Status: "started"})
+
+	if records, err := deployAudit.deploys("", "umbrellium/mario"); err != nil || len(records) != 1 {
+		t.Fatalf("Expected the deploy to be recorded, got %+v, %v", records, err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if records, err := deployAudit.deploys("", "umbrellium/mario"); err != nil || len(records) != 0 {
+		t.Errorf("Expected the record to expire, got %+v, %v", records, err)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// httpMux routes the requests to Mario's HTTP endpoints
+var httpMux = http.NewServeMux()
+
+// httpAddr returns the address of Mario's HTTP server,
+// HTTP_ADDR or every interface on PORT
+// Returns an empty string if Mario doesn't serve HTTP
+func httpAddr() string {
+	if addr := workspaceSetting("", "HTTP_ADDR"); addr != "" {
+		return addr
+	}
+
+	if port := workspaceSetting("", "PORT"); port != "" {
+		return ":" + port
+	}
+
+	return ""
+}
+
+// startHTTPServer serves the endpoints registered on httpMux in the background
+// Returns an error if Mario cannot listen on addr
+func startHTTPServer(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Handler:      httpMux,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+
+	go func() {
+		logs.error("HTTP server stopped", "err", server.Serve(listener))
+	}()
+
+	logs.info("serving HTTP", "addr", listener.Addr())
+
+	return nil
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// IRC is a chat adapter for IRC servers
+// it implements the chatAdapter interface defined in adapters.go
+type IRC struct {
+	// Server is the address of the IRC server, e.g. irc.example.com:6667
+	Server   string
+	TLS      bool
+	Nick     string
+	Password string
+	Channels []string
+
+	// flood protection: Mario can send Burst lines at once,
+	// then one line every Rate
+	Burst int
+	Rate  time.Duration
+
+	// Timeout is how long Mario waits for the server to send anything,
+	// servers ping their clients more often than that
+	Timeout time.Duration
+
+	mu         sync.Mutex
+	conn       net.Conn
+	reader     *bufio.Reader
+	nick       string
+	registered bool
+	// outgoing queues the lines to send, it's kept across connections,
+	// stopWriting stops the write loop of the current connection
+	outgoing    chan string
+	stopWriting chan struct{}
+	limiter     *floodLimiter
+}
+
+// ircMessage is a line received from the IRC server
+type ircMessage struct {
+	Prefix  string
+	Command string
+	Params  []string
+}
+
+// how long Mario waits for the server to accept a connection
+const ircDialTimeout = 30 * time.Second
+
+// maximum number of bytes of text sent in a single PRIVMSG
+// IRC lines are limited to 512 bytes including the command and target
+const ircMaxText = 400
+
+// newIRCAdapter creates an IRC adapter from the IRC_* settings
+// Returns the adapter and an error
+func newIRCAdapter() (chatAdapter, error) {
+	server := workspaceSetting("", "IRC_SERVER")
+	if server == "" {
+		return nil, fmt.Errorf("You must set irc.server or IRC_SERVER to connect to IRC")
+	}
+
+	nick := workspaceSetting("", "IRC_NICK")
+	if nick == "" {
+		nick = "mario"
+	}
+
+	var channels []string
+	for _, channel := range strings.Split(workspaceSetting("", "IRC_CHANNELS"), ",") {
+		if channel = strings.TrimSpace(channel); channel != "" {
+			channels = append(channels, channel)
+		}
+	}
+
+	return &IRC{
+		Server:   server,
+		TLS:      workspaceSetting("", "IRC_TLS") == "true",
+		Nick:     nick,
+		Password: workspaceSetting("", "IRC_PASSWORD"),
+		Channels: channels,
+	}, nil
+}
+
+// Connect registers Mario on the IRC server and joins the channels
+func (i *IRC) connect() error {
+	if i.Burst == 0 {
+		i.Burst = 4
+	}
+	if i.Rate == 0 {
+		i.Rate = time.Second
+	}
+	if i.Timeout == 0 {
+		i.Timeout = 5 * time.Minute
+	}
+
+	// the write loop of the previous connection stops before the next one starts
+	i.mu.Lock()
+	if i.stopWriting != nil {
+		close(i.stopWriting)
+		i.stopWriting = nil
+	}
+	if i.outgoing == nil {
+		i.outgoing = make(chan string, 256)
+		i.limiter = &floodLimiter{burst: i.Burst, rate: i.Rate}
+	}
+	i.mu.Unlock()
+
+	err := i.dial()
+
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+
+	i.mu.Lock()
+	i.stopWriting = stop
+	i.mu.Unlock()
+
+	go i.writeLoop(i.outgoing, stop)
+
+	return nil
+}
+
+// dial opens a connection to the server, registers Mario's nick and joins the channels
+func (i *IRC) dial() error {
+	var conn net.Conn
+	var err error
+
+	dialer := &net.Dialer{Timeout: ircDialTimeout}
+
+	if i.TLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", i.Server, nil)
+	} else {
+		conn, err = dialer.Dial("tcp", i.Server)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	i.conn = conn
+	i.reader = bufio.NewReader(conn)
+	i.nick = i.Nick
+	i.registered = false
+	i.mu.Unlock()
+
+	if i.Password != "" {
+		i.send("PASS " + i.Password)
+	}
+	i.send("NICK " + i.nick)
+	i.send("USER " + i.Nick + " 0 * :Mario the Bot")
+
+	// wait for the server to welcome Mario
+	for {
+		line, err := i.readLine()
+
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("Error: cannot register on IRC server: %v", err)
+		}
+
+		msg := parseIRCLine(line)
+
+		switch msg.Command {
+		case "PING":
+			i.send("PONG :" + msg.param(0))
+		case "433":
+			// nick already in use
+			i.mu.Lock()
+			i.nick += "_"
+			i.mu.Unlock()
+			i.send("NICK " + i.currentNick())
+		case "ERROR":
+			conn.Close()
+			return fmt.Errorf("Error: IRC server refused connection: %s", msg.param(0))
+		case "001":
+			// the server may have changed Mario's nick
+			i.mu.Lock()
+			if nick := msg.param(0); nick != "" {
+				i.nick = nick
+			}
+			i.registered = true
+			i.mu.Unlock()
+
+			for _, channel := range i.Channels {
+				i.send("JOIN " + channel)
+			}
+			return nil
+		}
+	}
+}
+
+// ping asks the server for a pong, the pongs show the connection is alive
+func (i *IRC) ping() error {
+	return i.send("PING :" + i.currentNick())
+}
+
+// GetMessage listens to IRC messages and answers pings
+// Returns the message, the pings and pongs, or an error once the connection is lost
+func (i *IRC) getMessage() (Message, error) {
+	var msg Message
+
+	for {
+		line, err := i.readLine()
+
+		if err != nil {
+			i.mu.Lock()
+			i.conn.Close()
+			i.registered = false
+			i.mu.Unlock()
+
+			return msg, fmt.Errorf("Error: lost connection to IRC server: %v", err)
+		}
+
+		ircMsg := parseIRCLine(line)
+
+		switch ircMsg.Command {
+		case "PING":
+			i.send("PONG :" + ircMsg.param(0))
+
+			// the server's pings show the connection is alive
+			msg.Type = "ping"
+			return msg, nil
+
+		case "PONG":
+			msg.Type = "pong"
+			return msg, nil
+
+		case "NICK":
+			if ircMsg.nick() == i.currentNick() {
+				i.mu.Lock()
+				i.nick = ircMsg.param(0)
+				i.mu.Unlock()
+			}
+
+		case "PRIVMSG":
+			text := ircMsg.param(1)
+
+			// ignore CTCP requests such as VERSION or ACTION
+			if strings.HasPrefix(text, "\x01") {
+				continue
+			}
+
+			msg.Type = "message"
+			msg.User = ircMsg.nick()
+			msg.Text = text
+			msg.Channel = ircMsg.param(0)
+
+			// private messages are answered to the sender
+			if !isIRCChannel(msg.Channel) {
+				msg.Channel = msg.User
+			}
+
+			return msg, nil
+		}
+	}
+}
+
+// PostMessage queues a message to be sent to IRC
+// IRC messages cannot contain new lines so every line is sent on its own
+// Returns an error if it couldn't complete the operation
+func (i *IRC) postMessage(msg Message) error {
+	msg = outgoingMessage(msg)
+
+	if msg.Channel == "" {
+		return fmt.Errorf("Error: cannot post IRC message without a channel")
+	}
+
+	for _, line := range strings.Split(msg.Text, "\n") {
+		line = strings.TrimRight(line, "\r")
+
+		// IRC does not allow empty messages
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		for _, chunk := range splitIRCText(line, ircMaxText) {
+			i.outgoing <- "PRIVMSG " + msg.Channel + " :" + chunk
+			queueDepth.set(float64(len(i.outgoing)), "irc")
+		}
+	}
+
+	return nil
+}
+
+// messages addressed to Mario start with his nick, e.g. "mario: hello"
+// private messages are always addressed to Mario
+func (i *IRC) command(msg Message) (string, bool) {
+	if msg.Channel == msg.User {
+		return msg.Text, true
+	}
+
+	nick := strings.ToLower(i.currentNick())
+	text := strings.TrimPrefix(msg.Text, "@")
+
+	if len(text) <= len(nick) || strings.ToLower(text[:len(nick)]) != nick {
+		return "", false
+	}
+
+	rest := text[len(nick):]
+
+	if rest[0] != ':' && rest[0] != ',' && rest[0] != ' ' {
+		return "", false
+	}
+
+	return rest[1:], true
+}
+
+// writeLoop sends the queued lines to the server without flooding it,
+// until the connection is replaced
+// a line that cannot be sent is retried, or queued again for the next connection
+func (i *IRC) writeLoop(outgoing chan string, stop chan struct{}) {
+	for {
+		var line string
+
+		select {
+		case <-stop:
+			return
+		case line = <-outgoing:
+		}
+
+		queueDepth.set(float64(len(outgoing)), "irc")
+		i.limiter.wait()
+
+		for i.sendRegistered(line) != nil {
+			select {
+			case <-stop:
+				select {
+				case outgoing <- line:
+				default:
+					logs.warn("dropped a line for IRC, the queue is full")
+				}
+				return
+			case <-time.After(i.Rate):
+			}
+		}
+	}
+}
+
+// send writes a single line to the server
+func (i *IRC) send(line string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	_, err := i.conn.Write([]byte(line + "\r\n"))
+	return err
+}
+
+// sendRegistered writes a single line once Mario is registered on the server
+func (i *IRC) sendRegistered(line string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if !i.registered {
+		return fmt.Errorf("not registered on IRC server")
+	}
+
+	_, err := i.conn.Write([]byte(line + "\r\n"))
+	return err
+}
+
+// readLine reads a single line from the server
+func (i *IRC) readLine() (string, error) {
+	i.mu.Lock()
+	conn, reader := i.conn, i.reader
+	i.mu.Unlock()
+
+	conn.SetReadDeadline(time.Now().Add(i.Timeout))
+
+	line, err := reader.ReadString('\n')
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (i *IRC) currentNick() string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.nick
+}
+
+// parseIRCLine parses a raw IRC line, e.g.
+// ":alice!alice@example.com PRIVMSG #mario :mario: hello"
+// Returns the parsed message
+func parseIRCLine(line string) ircMessage {
+	var msg ircMessage
+
+	// skip IRCv3 message tags
+	if strings.HasPrefix(line, "@") {
+		if i := strings.Index(line, " "); i >= 0 {
+			line = strings.TrimLeft(line[i+1:], " ")
+		}
+	}
+
+	if strings.HasPrefix(line, ":") {
+		i := strings.Index(line, " ")
+		if i < 0 {
+			msg.Prefix = line[1:]
+			return msg
+		}
+		msg.Prefix = line[1:i]
+		line = strings.TrimLeft(line[i+1:], " ")
+	}
+
+	var trailing string
+	hasTrailing := false
+
+	if i := strings.Index(line, " :"); i >= 0 {
+		trailing = line[i+2:]
+		hasTrailing = true
+		line = line[:i]
+	} else if strings.HasPrefix(line, ":") {
+		trailing = line[1:]
+		hasTrailing = true
+		line = ""
+	}
+
+	fields := strings.Fields(line)
+
+	if len(fields) > 0 {
+		msg.Command = strings.ToUpper(fields[0])
+		msg.Params = fields[1:]
+	}
+
+	if hasTrailing {
+		msg.Params = append(msg.Params, trailing)
+	}
+
+	return msg
+}
+
+// param returns the nth parameter of the message or an empty string
+func (m ircMessage) param(n int) string {
+	if n < len(m.Params) {
+		return m.Params[n]
+	}
+	return ""
+}
+
+// nick returns the nick of the user who sent the message
+func (m ircMessage) nick() string {
+	if i := strings.Index(m.Prefix, "!"); i >= 0 {
+		return m.Prefix[:i]
+	}
+	return m.Prefix
+}
+
+func isIRCChannel(target string) bool {
+	return target != "" && strings.ContainsAny(target[:1], "#&+!")
+}
+
+// splitIRCText splits text in chunks of at most max bytes,
+// preferably on spaces
+func splitIRCText(text string, max int) []string {
+	var chunks []string
+
+	for len(text) > max {
+		cut := strings.LastIndex(text[:max], " ")
+		if cut <= 0 {
+			// don't cut a character in half
+			for cut = max; cut > 0 && !utf8.RuneStart(text[cut]); cut-- {
+			}
+		}
+		chunks = append(chunks, text[:cut])
+		text = strings.TrimLeft(text[cut:], " ")
+	}
+
+	return append(chunks, text)
+}
+
+// floodLimiter stops Mario from flooding the server,
+// which would get him disconnected
+type floodLimiter struct {
+	mu    sync.Mutex
+	burst int
+	rate  time.Duration
+	next  time.Time
+}
+
+// wait blocks until another line can be sent
+func (l *floodLimiter) wait() {
+	l.mu.Lock()
+
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+
+	delay := l.next.Sub(now) - time.Duration(l.burst-1)*l.rate
+	l.next = l.next.Add(l.rate)
+
+	l.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeIRCServer is an in-process IRC server
+// that lets tests script the conversation with Mario
+type fakeIRCServer struct {
+	listener net.Listener
+	conns    chan *fakeIRCConn
+}
+
+type fakeIRCConn struct {
+	conn  net.Conn
+	lines chan string
+}
+
+func newFakeIRCServer(t *testing.T) *fakeIRCServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Cannot start fake IRC server: %v", err)
+	}
+
+	server := &fakeIRCServer{listener: listener, conns: make(chan *fakeIRCConn, 4)}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			c := &fakeIRCConn{conn: conn, lines: make(chan string, 64)}
+			go func() {
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					c.lines <- strings.TrimRight(scanner.Text(), "\r")
+				}
+				close(c.lines)
+			}()
+			server.conns <- c
+		}
+	}()
+
+	return server
+}
+
+// accept waits for Mario to connect and welcomes him
+func (s *fakeIRCServer) accept(t *testing.T) *fakeIRCConn {
+	select {
+	case c := <-s.conns:
+		c.expect(t, "NICK mario")
+		c.expect(t, "USER mario")
+		c.send(":fake.server 001 mario :Welcome to the fake IRC server")
+		return c
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Expected Mario to connect to the fake IRC server")
+	}
+	return nil
+}
+
+// expect waits for the next line sent by Mario and checks its beginning
+func (c *fakeIRCConn) expect(t *testing.T, prefix string) {
+	select {
+	case line := <-c.lines:
+		if !strings.HasPrefix(line, prefix) {
+			t.Fatalf("Expected Mario to send %q, got %q instead", prefix, line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Expected Mario to send %q, got nothing", prefix)
+	}
+}
+
+func (c *fakeIRCConn) send(line string) {
+	c.conn.Write([]byte(line + "\r\n"))
+}
+
+// test parsing raw IRC lines
+func TestParseIRCLine(t *testing.T) {
+	type ircTestingStruct struct {
+		input   string
+		prefix  string
+		command string
+		params  []string
+	}
+
+	ircParseTest := []ircTestingStruct{
+		{"PING :irc.example.com", "", "PING", []string{"irc.example.com"}},
+		{":alice!a@example.com PRIVMSG #mario :mario: hello there", "alice!a@example.com", "PRIVMSG", []string{"#mario", "mario: hello there"}},
+		{"@time=2016-01-01T00:00:00Z :bob PRIVMSG mario :help", "bob", "PRIVMSG", []string{"mario", "help"}},
+		{":server 001 mario :Welcome", "server", "001", []string{"mario", "Welcome"}},
+		{":server MODE mario +i", "server", "MODE", []string{"mario", "+i"}},
+	}
+
+	for _, tst := range ircParseTest {
+		res := parseIRCLine(tst.input)
+		if res.Prefix != tst.prefix || res.Command != tst.command || strings.Join(res.Params, "|") != strings.Join(tst.params, "|") {
+			t.Errorf("Expected %q to parse as %q %q %q, got %+v instead", tst.input, tst.prefix, tst.command, tst.params, res)
+		}
+	}
+}
+
+// test nick prefix mention detection
+func TestIRCCommand(t *testing.T) {
+	irc := &IRC{nick: "mario"}
+
+	type ircTestingStruct struct {
+		msg      Message
+		expected string
+		ok       bool
+	}
+
+	ircCommandTest := []ircTestingStruct{
+		{Message{Channel: "#mario", User: "alice", Text: "mario: hello"}, " hello", true},
+		{Message{Channel: "#mario", User: "alice", Text: "Mario, hello"}, " hello", true},
+		{Message{Channel: "#mario", User: "alice", Text: "@mario hello"}, "hello", true},
+		{Message{Channel: "#mario", User: "alice", Text: "marios: hello"}, "", false},
+		{Message{Channel: "#mario", User: "alice", Text: "hello mario"}, "", false},
+		{Message{Channel: "alice", User: "alice", Text: "hello"}, "hello", true},
+	}
+
+	for _, tst := range ircCommandTest {
+		res, ok := irc.command(tst.msg)
+		if res != tst.expected || ok != tst.ok {
+			t.Errorf("Expected %q to return %q %t, got %q %t instead", tst.msg.Text, tst.expected, tst.ok, res, ok)
+		}
+	}
+}
+
+// test that the limiter lets a burst through and then slows down
+func TestIRCFloodLimiter(t *testing.T) {
+	limiter := &floodLimiter{burst: 2, rate: 50 * time.Millisecond}
+	start := time.Now()
+
+	limiter.wait()
+	limiter.wait()
+	if elapsed := time.Since(start); elapsed > 25*time.Millisecond {
+		t.Errorf("Expected the first 2 lines to be sent at once, waited %v", elapsed)
+	}
+
+	limiter.wait()
+	limiter.wait()
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected the next 2 lines to be delayed, waited %v", elapsed)
+	}
+}
+
+// readIRC reads the messages of a connection to IRC, except the pings
+// the channel is closed once the connection is lost
+func readIRC(irc *IRC) <-chan Message {
+	received := make(chan Message)
+
+	go func() {
+		defer close(received)
+
+		for {
+			msg, err := irc.getMessage()
+			if err != nil {
+				return
+			}
+			if msg.Type != "ping" {
+				received <- msg
+			}
+		}
+	}()
+
+	return received
+}
+
+// connectIRC connects to the fake IRC server and waits for Mario to join his channels
+func connectIRC(t *testing.T, irc *IRC, server *fakeIRCServer) *fakeIRCConn {
+	connected := make(chan error)
+	go func() {
+		connected <- irc.connect()
+	}()
+
+	conn := server.accept(t)
+	if err := <-connected; err != nil {
+		t.Fatalf("Expected connect to return no error, got %v", err)
+	}
+	conn.expect(t, "JOIN #mario")
+
+	return conn
+}
+
+// test a session against the fake IRC server, including a reconnection
+func TestIRCSession(t *testing.T) {
+	server := newFakeIRCServer(t)
+	defer server.listener.Close()
+
+	irc := &IRC{
+		Server:   server.listener.Addr().String(),
+		Nick:     "mario",
+		Channels: []string{"#mario"},
+		Rate:     time.Millisecond,
+	}
+
+	conn := connectIRC(t, irc, server)
+	received := readIRC(irc)
+
+	conn.send("PING :fake.server")
+	conn.expect(t, "PONG :fake.server")
+
+	// Mario's pings show the connection is alive
+	if err := irc.ping(); err != nil {
+		t.Fatalf("Expected ping to return no error, got %v", err)
+	}
+	conn.expect(t, "PING :mario")
+	conn.send(":fake.server PONG fake.server :mario")
+
+	if msg := <-received; msg.Type != "pong" {
+		t.Fatalf("Expected the pong, got %+v", msg)
+	}
+
+	conn.send(":alice!alice@example.com PRIVMSG #mario :mario: hello")
+	msg := <-received
+	if msg.User != "alice" || msg.Channel != "#mario" {
+		t.Fatalf("Expected a message from alice in #mario, got %+v", msg)
+	}
+
+	if err := dispatch(irc, msg); err != nil {
+		t.Fatalf("Expected dispatch to return no error, got %v", err)
+	}
+	conn.expect(t, "PRIVMSG #mario :Yo!")
+
+	// multi line replies are sent one line at a time
+	irc.postMessage(Message{Channel: "alice", Text: "first\n\nsecond"})
+	conn.expect(t, "PRIVMSG alice :first")
+	conn.expect(t, "PRIVMSG alice :second")
+
+	// the connection is lost, main reconnects the adapter
+	conn.conn.Close()
+
+	if _, ok := <-received; ok {
+		t.Fatalf("Expected getMessage to fail once the connection is lost")
+	}
+
+	// what Mario posts meanwhile is sent once he's back, by a single write loop
+	irc.postMessage(Message{Channel: "#mario", Text: "still here"})
+
+	conn = connectIRC(t, irc, server)
+	received = readIRC(irc)
+	conn.expect(t, "PRIVMSG #mario :still here")
+
+	conn.send(":bob!bob@example.com PRIVMSG mario :hello")
+	msg = <-received
+	if msg.User != "bob" || msg.Channel != "bob" {
+		t.Fatalf("Expected a private message from bob, got %+v", msg)
+	}
+}
+
+// test the write loop stops when its connection is replaced
+func TestIRCWriteLoopStops(t *testing.T) {
+	irc := &IRC{Rate: time.Millisecond, limiter: &floodLimiter{burst: 1, rate: time.Millisecond}}
+
+	outgoing := make(chan string, 2)
+	stop := make(chan struct{})
+	stopped := make(chan bool)
+
+	go func() {
+		irc.writeLoop(outgoing, stop)
+		close(stopped)
+	}()
+
+	// Mario isn't registered, the line waits for the next connection
+	outgoing <- "PRIVMSG #mario :hello"
+	time.Sleep(10 * time.Millisecond)
+	close(stop)
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the write loop to stop")
+	}
+
+	if len(outgoing) != 1 || <-outgoing != "PRIVMSG #mario :hello" {
+		t.Errorf("Expected the line to be queued again")
+	}
+}
@@ -0,0 +1,548 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/umbrellium/mario/brain"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Locks struct
+// locks apps so that nobody deploys them, e.g. during a release freeze
+type Locks struct {
+}
+
+// deployLock stops the deploys of an app, or of every app when App is "all"
+// Mario locks an app himself while he deploys it
+type deployLock struct {
+	Workspace string    `json:"workspace,omitempty"`
+	App       string    `json:"app"`
+	User      string    `json:"user"`
+	Channel   string    `json:"channel"`
+	Reason    string    `json:"reason,omitempty"`
+	Created   time.Time `json:"created"`
+	// a lock without expiry lasts until it's unlocked
+	Expires time.Time `json:"expires,omitempty"`
+	Deploy  bool      `json:"deploy,omitempty"`
+}
+
+// lockList holds the deploy locks of every workspace in the brain,
+// keyed by "<workspace>/<app>"
+type lockList struct {
+	mu sync.Mutex
+}
+
+// locks that stop the deploys
+var locks = &lockList{}
+
+// how often Mario looks for expired locks
+var lockExpiryInterval = time.Minute
+
+func lockKey(workspace, app string) string {
+	return workspace + "/" + app
+}
+
+// expired tells whether a lock expired at a given time
+func (l *deployLock) expired(now time.Time) bool {
+	return !l.Expires.IsZero() && !now.Before(l.Expires)
+}
+
+// store returns the part of the brain where the locks are kept
+func (c *lockList) store() brain.Store {
+	return taskBrain(Locks{})
+}
+
+// get reads a lock from the brain
+// Returns the lock and its encoded value, nil if there is no lock
+func (c *lockList) get(key string) (*deployLock, []byte, error) {
+	raw, err := c.store().Get(key)
+
+	if err == brain.ErrNotFound {
+		return nil, nil, nil
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	l := &deployLock{}
+	if err := json.Unmarshal(raw, l); err != nil {
+		return nil, nil, fmt.Errorf("invalid lock %s: %v", key, err)
+	}
+
+	return l, raw, nil
+}
+
+// blocking returns the lock that stops the deploys of an app, if any
+func (c *lockList) blocking(workspace, app string) (*deployLock, error) {
+	now := time.Now()
+
+	for _, key := range []string{lockKey(workspace, app), lockKey(workspace, "all")} {
+		l, _, err := c.get(key)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if l != nil && !l.expired(now) {
+			return l, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// lock adds a lock, or updates the lock its owner already holds
+// a deploy lock can only be taken if nothing blocks the deploys of the app
+// Returns the lock in the way if the app is already locked
+func (c *lockList) lock(l deployLock) (*deployLock, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	key := lockKey(l.Workspace, l.App)
+
+	current, raw, err := c.get(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if l.Deploy {
+		held, err := c.blocking(l.Workspace, l.App)
+		if err != nil || held != nil {
+			return held, err
+		}
+	} else if current != nil && !current.expired(now) && (current.User != l.User || current.Deploy) {
+		return current, nil
+	}
+
+	// an expired lock would be kept forever, the brain keeps the keys without ttl
+	if !l.Expires.IsZero() && !l.Expires.After(now) {
+		return nil, fmt.Errorf("the lock of %s would expire at once", lockedApp(l.App))
+	}
+
+	l.Created = now
+	value, err := json.Marshal(l)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the brain forgets the locks a day after they expired,
+	// in case Mario wasn't running to announce it
+	var ttl time.Duration
+	if !l.Expires.IsZero() {
+		ttl = l.Expires.Sub(now) + 24*time.Hour
+	}
+
+	ok, err := c.store().CompareAndSwap(key, raw, value, ttl)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		// another Mario sharing the brain locked the app first
+		held, _, err := c.get(key)
+		if err == nil && held == nil {
+			err = fmt.Errorf("the lock of %s changed, try again", lockedApp(l.App))
+		}
+		return held, err
+	}
+
+	return nil, nil
+}
+
+// unlock removes the lock of an app on behalf of a user,
+// only its owner or an admin can, and nobody can remove the lock Mario takes while he deploys
+// Returns the lock, nil if the app wasn't locked, and whether it was removed
+func (c *lockList) unlock(workspace, app, user string, admin bool) (*deployLock, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := lockKey(workspace, app)
+
+	for {
+		l, raw, err := c.get(key)
+
+		if err != nil || l == nil || l.expired(time.Now()) {
+			return nil, false, err
+		}
+
+		if l.Deploy || (l.User != user && !admin) {
+			return l, false, nil
+		}
+
+		// the lock is only removed if nobody changed it since it was checked
+		ok, err := c.store().CompareAndSwap(key, raw, nil, 0)
+
+		if err != nil || ok {
+			return l, ok, err
+		}
+	}
+}
+
+// release removes the lock Mario took to deploy an app
+func (c *lockList) release(workspace, app string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := lockKey(workspace, app)
+	l, _, err := c.get(key)
+
+	if err == nil && l != nil && l.Deploy {
+		err = c.store().Delete(key)
+	}
+
+	if err != nil {
+		logs.error("cannot release the deploy lock", "workspace", workspace, "app", app, "err", err)
+	}
+}
+
+// all returns every lock of a workspace, with the expired ones, sorted by app
+func (c *lockList) all(workspace string) ([]deployLock, error) {
+	keys, err := c.store().List(lockKey(workspace, ""))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var all []deployLock
+
+	for _, key := range keys {
+		l, _, err := c.get(key)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if l != nil {
+			all = append(all, *l)
+		}
+	}
+
+	sort.Slice(all, func(i, j int) bool { return all[i].App < all[j].App })
+
+	return all, nil
+}
+
+// list returns the current locks of a workspace, sorted by app
+func (c *lockList) list(workspace string) ([]deployLock, error) {
+	all, err := c.all(workspace)
+	now := time.Now()
+
+	var current []deployLock
+
+	for _, l := range all {
+		if !l.expired(now) {
+			current = append(current, l)
+		}
+	}
+
+	return current, err
+}
+
+// expire removes the locks of a workspace that expired
+// Returns the removed locks
+func (c *lockList) expire(workspace string, now time.Time) []deployLock {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	all, err := c.all(workspace)
+
+	if err != nil {
+		logs.error("cannot read the deploy locks", "workspace", workspace, "err", err)
+	}
+
+	var expired []deployLock
+
+	for _, l := range all {
+		if !l.expired(now) {
+			continue
+		}
+
+		if err := c.store().Delete(lockKey(l.Workspace, l.App)); err != nil {
+			logs.error("cannot remove the expired lock", "workspace", l.Workspace, "app", l.App, "err", err)
+			continue
+		}
+
+		expired = append(expired, l)
+	}
+
+	return expired
+}
+
+// startLocks announces the locks that expire
+func startLocks(chats []chatAdapter) {
+	for _, chat := range chats {
+		chat := chat
+		workspace := workspaceOf(chat)
+
+		jobs.every("lock expiry "+workspace, lockExpiryInterval, func() error {
+			for _, l := range locks.expire(workspace, time.Now()) {
+				if !l.Deploy {
+					announceLock(chat, Message{Type: "message", Channel: l.Channel, Workspace: l.Workspace},
+						"The lock on "+lockedApp(l.App)+" expired, it can be deployed again.")
+				}
+			}
+			return nil
+		})
+	}
+}
+
+// announceLock posts a change of the locks in the channel of the message
+// and in LOCKS_CHANNEL, if it's set
+func announceLock(slack chatAgent, message Message, text string) error {
+	message.Text = text
+	err := slack.postMessage(message)
+
+	channel := workspaceSetting(message.Workspace, "LOCKS_CHANNEL")
+
+	if channel != "" && channel != message.Channel {
+		if err := slack.postMessage(Message{Type: "message", Channel: channel, Workspace: message.Workspace, Text: text}); err != nil {
+			messageLog(message).error("cannot announce the lock", "locks_channel", channel, "err", err)
+		}
+	}
+
+	return err
+}
+
+// lockedApp names the app of a lock
+func lockedApp(app string) string {
+	if app == "all" {
+		return "all apps"
+	}
+	return app
+}
+
+// formatLock describes a lock on a single line
+func formatLock(l deployLock) string {
+	text := fmt.Sprintf("%s locked by <@%s>", lockedApp(l.App), l.User)
+
+	if l.Deploy {
+		text = fmt.Sprintf("%s locked while <@%s> deploys it", l.App, l.User)
+	}
+
+	if !l.Expires.IsZero() {
+		text += " until " + l.Expires.Format("2 Jan 15:04 MST")
+	}
+
+	if l.Reason != "" {
+		text += ": " + l.Reason
+	}
+
+	return text
+}
+
+// parseUntil parses when a lock expires: a duration such as 2h,
+// a time today or tomorrow such as 18:00, a day such as 2016-01-02
+// (the lock lasts the whole day) or a day and a time such as 2016-01-02 18:00
+func parseUntil(text string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(text); err == nil && d > 0 {
+		return now.Add(d), nil
+	}
+
+	if t, err := time.ParseInLocation("15:04", text, now.Location()); err == nil {
+		until := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+		if !until.After(now) {
+			until = until.AddDate(0, 0, 1)
+		}
+		return until, nil
+	}
+
+	until, ok := parseDay(text, now)
+
+	if !ok {
+		return time.Time{}, fmt.Errorf("I don't understand when %q is, try 2h, 18:00, 2016-01-02 or 2016-01-02 18:00", text)
+	}
+
+	if !until.After(now) {
+		return time.Time{}, fmt.Errorf("%s is already past, a lock must last until later", text)
+	}
+
+	return until, nil
+}
+
+// parseDay parses a day, which lasts until the next one, or a day and a time
+// Returns the time and true if the text is a day
+func parseDay(text string, now time.Time) (time.Time, bool) {
+	if t, err := time.ParseInLocation("2006-01-02", text, now.Location()); err == nil {
+		return t.AddDate(0, 0, 1), true
+	}
+
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02T15:04", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, text, now.Location()); err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+func (s Locks) Hear(slack chatAgent, message Message, input string) bool {
+	patter, err := regexp.Compile(`^\b(lock|unlock|locks)\b`)
+
+	if err != nil {
+		taskLog(s, message).error("cannot parse the input", "err", err)
+	}
+
+	if !patter.MatchString(input) {
+		return false
+	}
+
+	options := strings.Fields(input)
+
+	if len(options) > 1 && options[1] == "help" {
+		err := s.Help(slack, message)
+		if err != nil {
+			taskLog(s, message).error("cannot post the help", "err", err)
+			return false
+		}
+		return true
+	}
+
+	switch {
+	case options[0] == "locks" && len(options) == 1:
+		err = s.list(slack, message)
+	case options[0] == "lock" && len(options) > 1:
+		err = s.lock(slack, message, options[1], options[2:])
+	case options[0] == "unlock" && len(options) == 2:
+		err = s.unlock(slack, message, options[1])
+	default:
+		message.Text = "Usage: @mario lock <app|all> [reason] [until <time>], @mario unlock <app|all> or @mario locks"
+		err = slack.postMessage(message)
+	}
+
+	if err != nil {
+		taskLog(s, message).error("cannot handle the deploy locks", "err", err)
+	}
+	return true
+}
+
+// appToLock names the app of a command, "all" locks every app
+func appToLock(message Message, arg string) string {
+	if arg == "all" {
+		return arg
+	}
+	org, app := werckerApp(message, arg)
+	return org + "/" + app
+}
+
+// lock locks an app, the last words of the command may say until when
+func (s Locks) lock(slack chatAgent, message Message, arg string, words []string) error {
+	l := deployLock{Workspace: message.Workspace, App: appToLock(message, arg), User: message.User, Channel: message.Channel}
+
+	for i := len(words) - 1; i >= 0; i-- {
+		if words[i] != "until" {
+			continue
+		}
+
+		until, err := parseUntil(strings.Join(words[i+1:], " "), time.Now())
+
+		if err != nil {
+			message.Text = err.Error()
+			return slack.postMessage(message)
+		}
+
+		l.Expires = until
+		words = words[:i]
+		break
+	}
+
+	l.Reason = strings.Join(words, " ")
+
+	held, err := locks.lock(l)
+
+	if err != nil {
+		message.Text = "Sorry, I couldn't lock " + lockedApp(l.App) + ": " + err.Error()
+		slack.postMessage(message)
+		return err
+	}
+
+	if held != nil {
+		message.Text = "Sorry, " + formatLock(*held) + "."
+		return slack.postMessage(message)
+	}
+
+	return announceLock(slack, message, formatLock(l)+".")
+}
+
+// unlock removes the lock of an app, only its owner and the admins can
+func (s Locks) unlock(slack chatAgent, message Message, arg string) error {
+	app := appToLock(message, arg)
+	held, removed, err := locks.unlock(message.Workspace, app, message.User, isAdmin(message.Workspace, message.User))
+
+	if err != nil {
+		message.Text = "Sorry, I couldn't unlock " + lockedApp(app) + ": " + err.Error()
+		slack.postMessage(message)
+		return err
+	}
+
+	switch {
+	case held == nil:
+		message.Text = lockedApp(app) + " isn't locked."
+		return slack.postMessage(message)
+	case held.Deploy:
+		message.Text = "Sorry, " + formatLock(*held) + ", the lock goes away when the deploy finishes."
+		return slack.postMessage(message)
+	case !removed:
+		message.Text = "Sorry, only <@" + held.User + "> or an admin can unlock " + lockedApp(app) + "."
+		return slack.postMessage(message)
+	}
+
+	return announceLock(slack, message, "<@"+message.User+"> unlocked "+lockedApp(app)+".")
+}
+
+// list posts the current locks
+func (s Locks) list(slack chatAgent, message Message) error {
+	current, err := locks.list(message.Workspace)
+
+	if err != nil {
+		message.Text = "Sorry, I couldn't read the locks: " + err.Error()
+		slack.postMessage(message)
+		return err
+	}
+
+	if len(current) == 0 {
+		message.Text = "Nothing is locked, every app can be deployed."
+		return slack.postMessage(message)
+	}
+
+	message.Text = "Current locks:\n"
+	for _, l := range current {
+		message.Text += "- " + formatLock(l) + "\n"
+	}
+
+	return slack.postMessage(message)
+}
+
+func (s Locks) Help(slack chatAgent, message Message) error {
+	message.Text = `<lock> stops the deploys of an app, or of every app, until it's unlocked.
+Usage:
+- @mario lock <app|all> [reason] [until <time>]
+- @mario unlock <app|all>
+- @mario locks
+
+The time can be a duration (2h), a time (18:00), a day (2016-01-02) or both (2016-01-02 18:00).
+Only the person who locked an app or an admin can unlock it.
+I also lock an app while I deploy it.
+`
+
+	err := slack.postMessage(message)
+
+	if err != nil {
+		taskLog(s, message).error("cannot post the help", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s Locks) getName() string {
+	return "lock"
+}
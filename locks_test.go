@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// test the <lock>, <unlock> and <locks> commands
+func TestLocks(t *testing.T) {
+	defer useBrain(t)()
+
+	os.Setenv("MARIO_ADMINS", "Uadmin")
+	os.Setenv("LOCKS_CHANNEL", "C0LOCKS")
+	defer os.Unsetenv("MARIO_ADMINS")
+	defer os.Unsetenv("LOCKS_CHANNEL")
+
+	var chat FakeSlackChat
+	task := Locks{}
+
+	var tests = []struct {
+		user      string
+		input     string
+		expected  []string
+		announced bool
+	}{
+		{"Ualice", "locks", []string{"Nothing is locked, every app can be deployed."}, false},
+		{"Ualice", "lock mario release freeze until 2999-01-02 18:00", []string{"umbrellium/mario locked by <@Ualice> until 2 Jan 18:00", ": release freeze."}, true},
+		{"Ubob", "lock mario hotfix", []string{"Sorry, umbrellium/mario locked by <@Ualice>"}, false},
+		{"Ubob", "lock all until tomorrow", []string{"I don't understand when \"tomorrow\" is"}, false},
+		{"Ubob", "lock all", []string{"all apps locked by <@Ubob>."}, true},
+		{"Ualice", "locks", []string{"Current locks:\n- all apps locked by <@Ubob>\n- umbrellium/mario locked by <@Ualice>"}, false},
+		{"Ubob", "unlock mario", []string{"Sorry, only <@Ualice> or an admin can unlock umbrellium/mario."}, false},
+		{"Uadmin", "unlock mario", []string{"<@Uadmin> unlocked umbrellium/mario."}, true},
+		{"Ubob", "unlock mario", []string{"umbrellium/mario isn't locked."}, false},
+		{"Ubob", "unlock", []string{"Usage: @mario lock <app|all>"}, false},
+		{"Ubob", "lock help", []string{"<lock> stops the deploys of an app"}, false},
+	}
+
+	for _, tst := range tests {
+		message := Message{Type: "message", Channel: "C1", User: tst.user}
+
+		if !task.Hear(&chat, message, tst.input) {
+			t.Fatalf("Expected %q to be handled", tst.input)
+		}
+
+		replies := chat.replies()
+		if len(replies) == 0 {
+			t.Fatalf("Expected %q to post a reply", tst.input)
+		}
+
+		for _, expected := range tst.expected {
+			if !strings.Contains(replies[0].Text, expected) {
+				t.Errorf("Expected %q to reply %q, got %q", tst.input, expected, replies[0].Text)
+			}
+		}
+
+		// changes are announced in LOCKS_CHANNEL too
+		if tst.announced && (len(replies) != 2 || replies[1].Channel != "C0LOCKS" || replies[1].Text != replies[0].Text) {
+			t.Errorf("Expected %q to be announced in C0LOCKS, got %+v", tst.input, replies)
+		}
+
+		if !tst.announced && len(replies) != 1 {
+			t.Errorf("Expected %q to post a single reply, got %+v", tst.input, replies)
+		}
+	}
+
+	if task.Hear(&chat, msg, "lockdown") {
+		t.Errorf("Expected an invalid lock command not to be handled")
+	}
+
+	// the locks are kept in the brain
+	if keys, _ := brainStore.List(""); len(keys) != 1 || keys[0] != "lock:/all" {
+		t.Errorf("Expected the lock on all apps to be in the brain, got %v", keys)
+	}
+}
+
+// test locks expire
+func TestLockExpiry(t *testing.T) {
+	defer useBrain(t)()
+
+	locks.lock(deployLock{App: "umbrellium/mario", User: "Ualice", Expires: time.Now().Add(time.Hour)})
+
+	// a lock that expired while nobody announced it, e.g. Mario was stopped
+	expiredLock, _ := json.Marshal(deployLock{App: "umbrellium/luigi", User: "Ualice", Expires: time.Now().Add(-time.Second)})
+	locks.store().Set(lockKey("", "umbrellium/luigi"), expiredLock, time.Hour)
+
+	if held, err := locks.blocking("", "umbrellium/luigi"); held != nil || err != nil {
+		t.Errorf("Expected an expired lock not to block deploys, got %+v, %v", held, err)
+	}
+
+	expired := locks.expire("", time.Now().Add(2*time.Hour))
+	if current, _ := locks.list(""); len(expired) != 2 || expired[0].App != "umbrellium/luigi" || len(current) != 0 {
+		t.Errorf("Expected both locks to expire, got %+v", expired)
+	}
+}
+
+// test locks stop the deploys
+func TestLockedDeploy(t *testing.T) {
+	defer useBrain(t)()
+	defer useFakeWercker(t, fakeWerckerDeploys())()
+
+	os.Setenv("WERCKER_DEPLOYERS", "staging=*")
+	defer os.Unsetenv("WERCKER_DEPLOYERS")
+
+	chat := &transcriptChat{}
+	say := func(user, text string) string {
+		dispatch(chat, Message{Type: "message", Channel: "C1", User: user, Ts: "1.0", Text: "@mario " + text})
+		replies := chat.replies()
+		if len(replies) == 0 {
+			t.Fatalf("Expected %q to post a reply", text)
+		}
+		return replies[0].Text
+	}
+
+	say("Ualice", "lock all release")
+	if reply := say("Ubob", "deploy mario master to staging"); reply != "Sorry, all apps locked by <@Ualice>: release." {
+		t.Errorf("Expected the deploy to be locked, got %q", reply)
+	}
+
+	// the app is locked between the confirmation and the deploy
+	say("Ualice", "unlock all")
+	say("Ubob", "deploy mario master to staging")
+	say("Ualice", "lock mario")
+
+	if reply := say("Ubob", "yes"); reply != "Sorry, umbrellium/mario locked by <@Ualice>." {
+		t.Errorf("Expected the confirmed deploy to be locked, got %q", reply)
+	}
+
+	// Mario locks the app while he deploys it
+	locks.unlock("", "umbrellium/mario", "Ualice", false)
+	locks.lock(deployLock{App: "umbrellium/mario", User: "Ubob", Reason: "deploying build `b1` to staging", Deploy: true})
+
+	if reply := say("Ualice", "lock mario"); reply != "Sorry, umbrellium/mario locked while <@Ubob> deploys it: deploying build `b1` to staging." {
+		t.Errorf("Expected the deploy lock to stop other locks, got %q", reply)
+	}
+
+	// even the deployer cannot remove it
+	if reply := say("Ubob", "unlock mario"); reply != "Sorry, umbrellium/mario locked while <@Ubob> deploys it: deploying build `b1` to staging, the lock goes away when the deploy finishes." {
+		t.Errorf("Expected the deploy lock not to be removed, got %q", reply)
+	}
+
+	if _, removed, _ := locks.unlock("", "umbrellium/mario", "Uadmin", true); removed {
+		t.Errorf("Expected an admin not to remove the deploy lock")
+	}
+
+	locks.release("", "umbrellium/mario")
+
+	if held, _ := locks.blocking("", "umbrellium/mario"); held != nil {
+		t.Errorf("Expected the deploy lock to be released, got %+v", held)
+	}
+}
+
+// test the times a lock can last until
+func TestParseUntil(t *testing.T) {
+	now := time.Date(2016, 1, 2, 15, 0, 0, 0, time.UTC)
+
+	var tests = []struct {
+		text     string
+		expected time.Time
+	}{
+		{"2h", now.Add(2 * time.Hour)},
+		{"18:00", time.Date(2016, 1, 2, 18, 0, 0, 0, time.UTC)},
+		{"09:30", time.Date(2016, 1, 3, 9, 30, 0, 0, time.UTC)},
+		{"2016-01-05", time.Date(2016, 1, 6, 0, 0, 0, 0, time.UTC)},
+		{"2016-01-05 12:00", time.Date(2016, 1, 5, 12, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tst := range tests {
+		until, err := parseUntil(tst.text, now)
+		if err != nil || !until.Equal(tst.expected) {
+			t.Errorf("Expected %q to be %s, got %s, %v", tst.text, tst.expected, until, err)
+		}
+	}
+
+	if _, err := parseUntil("-2h", now); err == nil {
+		t.Errorf("Expected a negative duration to be rejected")
+	}
+
+	for _, past := range []string{"2016-01-01", "2016-01-02 14:00", "2015-12-25T10:00:00Z"} {
+		if _, err := parseUntil(past, now); err == nil || !strings.Contains(err.Error(), "already past") {
+			t.Errorf("Expected %q to be rejected as past, got %v", past, err)
+		}
+	}
+}
+
+// test a lock cannot expire before it's taken
+func TestExpiredLock(t *testing.T) {
+	defer useBrain(t)()
+
+	if _, err := locks.lock(deployLock{App: "umbrellium/mario", User: "Ualice", Expires: time.Now().Add(-48 * time.Hour)}); err == nil {
+		t.Errorf("Expected an expired lock to be refused")
+	}
+
+	if held, err := locks.blocking("", "umbrellium/mario"); err != nil || held != nil {
+		t.Errorf("Expected the app not to be locked, got %+v, %v", held, err)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logLevel orders log entries by importance
+type logLevel int
+
+const (
+	debugLevel logLevel = iota
+	infoLevel
+	warnLevel
+	errorLevel
+)
+
+var levelNames = map[logLevel]string{
+	debugLevel: "debug",
+	infoLevel:  "info",
+	warnLevel:  "warn",
+	errorLevel: "error",
+}
+
+// parseLogLevel reads a level name, e.g. "warn"
+// Returns the level and true if the name is known
+func parseLogLevel(name string) (logLevel, bool) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(levelName, name) {
+			return level, true
+		}
+	}
+	return infoLevel, false
+}
+
+// minLogLevel is the level of the least important entries Mario writes,
+// LOG_LEVEL or info by default
+func minLogLevel() logLevel {
+	level, _ := parseLogLevel(workspaceSetting("", "LOG_LEVEL"))
+	return level
+}
+
+// logger writes structured log entries in logfmt, one per line, e.g.
+// time=2016-01-02T15:04:05Z level=info msg="handled command" id=4f2a9c1e0b7d3a65 channel=C024BE91L task=deploy
+type logger struct {
+	// fields are key value pairs added to every entry
+	fields []interface{}
+}
+
+var (
+	logMu sync.Mutex
+	// logOutput receives the log entries, secrets are redacted from them
+	logOutput io.Writer = redactingWriter{os.Stderr}
+)
+
+// logs is the logger of everything that isn't about a message
+var logs = logger{}
+
+// with returns a logger that adds fields, key value pairs, to every entry
+func (l logger) with(fields ...interface{}) logger {
+	all := make([]interface{}, 0, len(l.fields)+len(fields))
+	return logger{fields: append(append(all, l.fields...), fields...)}
+}
+
+func (l logger) debug(msg string, fields ...interface{}) { l.write(debugLevel, msg, fields) }
+func (l logger) info(msg string, fields ...interface{})  { l.write(infoLevel, msg, fields) }
+func (l logger) warn(msg string, fields ...interface{})  { l.write(warnLevel, msg, fields) }
+func (l logger) error(msg string, fields ...interface{}) { l.write(errorLevel, msg, fields) }
+
+// fatal writes an error entry and stops Mario
+func (l logger) fatal(msg string, fields ...interface{}) {
+	l.write(errorLevel, msg, fields)
+	os.Exit(1)
+}
+
+// write formats an entry, fields with an empty value are left out
+func (l logger) write(level logLevel, msg string, fields []interface{}) {
+	all := append(append([]interface{}{}, l.fields...), fields...)
+
+	if level == errorLevel {
+		errorsLogged.inc()
+	}
+
+	if level < minLogLevel() {
+		return
+	}
+
+	entry := []string{
+		"time=" + time.Now().UTC().Format(time.RFC3339),
+		"level=" + levelNames[level],
+		"msg=" + logfmtValue(msg),
+	}
+
+	for i := 0; i+1 < len(all); i += 2 {
+		value := logfmtValue(all[i+1])
+
+		if value == `""` {
+			continue
+		}
+
+		entry = append(entry, fmt.Sprint(all[i])+"="+value)
+	}
+
+	logMu.Lock()
+	defer logMu.Unlock()
+
+	fmt.Fprintln(logOutput, strings.Join(entry, " "))
+}
+
+// logfmtValue formats a value, quoted if it contains spaces, quotes or equal signs
+func logfmtValue(value interface{}) string {
+	var text string
+
+	switch v := value.(type) {
+	case nil:
+		text = ""
+	case error:
+		text = v.Error()
+	case time.Duration:
+		text = v.String()
+	default:
+		text = fmt.Sprint(v)
+	}
+
+	if text == "" || strings.ContainsAny(text, " =\"\\\t\n") {
+		return strconv.Quote(text)
+	}
+
+	return text
+}
+
+// newCorrelationID identifies an incoming message,
+// every entry logged while Mario handles it has the same ID
+func newCorrelationID() string {
+	id := make([]byte, 8)
+
+	if _, err := rand.Read(id); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+
+	return hex.EncodeToString(id)
+}
+
+// messageLog returns the logger of a message,
+// with its correlation ID, workspace, channel and user
+func messageLog(msg Message) logger {
+	return logs.with("id", msg.CorrelationID, "workspace", msg.Workspace, "channel", msg.Channel, "user", msg.User)
+}
+
+// taskLog returns the logger of a task handling a message
+func taskLog(task Task, msg Message) logger {
+	return messageLog(msg).with("task", task.getName())
+}
+
+// stdLogWriter turns what is written with the log package,
+// e.g. by a library, into info entries
+type stdLogWriter struct{}
+
+func (stdLogWriter) Write(p []byte) (int, error) {
+	logs.info(strings.TrimSpace(string(p)))
+	return len(p), nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useLogOutput captures the log entries
+// Returns a function that restores the previous output
+func useLogOutput(out io.Writer) func() {
+	previous := logOutput
+	logOutput = out
+
+	return func() {
+		logOutput = previous
+	}
+}
+
+// test the format of the log entries
+func TestLogger(t *testing.T) {
+	var out bytes.Buffer
+	defer useLogOutput(&out)()
+
+	logs.with("id", "4f2a", "user", "").info("handled command", "task", "deploy", "duration", 1500*time.Millisecond, "err", fmt.Errorf(`bad "input"`))
+
+	entry := regexp.MustCompile(`^time=\S+Z level=info msg="handled command" id=4f2a task=deploy duration=1.5s err="bad \\"input\\""\n$`)
+	if !entry.MatchString(out.String()) {
+		t.Errorf("Expected a logfmt entry, got %q", out.String())
+	}
+
+	out.Reset()
+	logs.debug("hidden")
+	logs.warn("shown")
+
+	if strings.Contains(out.String(), "hidden") || !strings.Contains(out.String(), "level=warn msg=shown") {
+		t.Errorf("Expected only the entries of info and above, got %q", out.String())
+	}
+
+	os.Setenv("LOG_LEVEL", "error")
+	defer os.Unsetenv("LOG_LEVEL")
+
+	out.Reset()
+	logs.warn("hidden")
+
+	if out.Len() > 0 {
+		t.Errorf("Expected LOG_LEVEL to hide the warnings, got %q", out.String())
+	}
+}
+
+// test every entry about a command has its correlation ID
+func TestCorrelationID(t *testing.T) {
+	var out bytes.Buffer
+	defer useLogOutput(&out)()
+
+	os.Setenv("LOG_LEVEL", "debug")
+	defer os.Unsetenv("LOG_LEVEL")
+
+	shell := &Shell{Out: &bytes.Buffer{}, User: "developer", Channel: "shell"}
+
+	for _, text := range []string{"hello", "hello"} {
+		if err := dispatch(shell, Message{Type: "message", Channel: "shell", User: "developer", Text: text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries := strings.Split(strings.TrimSpace(out.String()), "\n")
+	expected := []string{
+		`msg="received command" id=(\w+) channel=shell user=developer text=hello`,
+		`msg="posting message" id=(\w+) channel=shell user=developer`,
+		`msg="handled command" id=(\w+) channel=shell user=developer task=hello duration=`,
+	}
+
+	if len(entries) != 2*len(expected) {
+		t.Fatalf("Expected %d entries, got %q", 2*len(expected), entries)
+	}
+
+	var ids []string
+
+	for i, entry := range entries {
+		match := regexp.MustCompile(expected[i%len(expected)]).FindStringSubmatch(entry)
+
+		if match == nil {
+			t.Fatalf("Expected %q, got %q", expected[i%len(expected)], entry)
+		}
+
+		ids = append(ids, match[1])
+	}
+
+	if ids[0] != ids[1] || ids[0] != ids[2] {
+		t.Errorf("Expected the entries of a command to share their ID, got %q", ids)
+	}
+
+	if ids[0] == ids[3] {
+		t.Errorf("Expected every command to have its own ID, got %q", ids)
+	}
+}
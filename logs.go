@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"github.com/umbrellium/mario/wercker"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// WerckerLogs struct
+// posts the interesting part of the log of a build step
+type WerckerLogs struct {
+}
+
+var (
+	// how many lines of a log Mario posts by default
+	logLines = 30
+	// the most lines Mario posts
+	maxLogLines = 200
+	// how many lines Mario keeps before the first error
+	logContext = 10
+	// the longest log line Mario posts, longer lines are cut
+	maxLogLineLength = 300
+)
+
+// errorPattern matches the lines that usually explain why a step failed
+var errorPattern = regexp.MustCompile(`(?i)\b(error|errors|fail|failed|failure|fatal|panic|exception)\b`)
+
+// ansiPattern matches the terminal colour codes of the logs
+var ansiPattern = regexp.MustCompile("\x1b\\[[0-9;]*[A-Za-z]")
+
+func (s WerckerLogs) Hear(slack chatAgent, message Message, input string) bool {
+	patter, err := regexp.Compile(`^\blogs\b`)
+
+	if err != nil {
+		taskLog(s, message).error("cannot parse the input", "err", err)
+	}
+
+	if !patter.MatchString(input) {
+		return false
+	}
+
+	options := strings.Fields(input)
+
+	if len(options) == 1 || options[1] == "help" {
+		err := s.Help(slack, message)
+		if err != nil {
+			taskLog(s, message).error("cannot post the help", "err", err)
+			return false
+		}
+		return true
+	}
+
+	args, flags, err := parseFlags(options[1:])
+
+	if err != nil || len(args) < 2 {
+		message.Text = "Usage: @mario logs <app> <build id> [step] [--lines <number>]"
+		slack.postMessage(message)
+		return true
+	}
+
+	lines := logLines
+
+	if value, ok := flags["lines"]; ok {
+		lines, err = strconv.Atoi(value)
+		if err != nil || lines < 1 || lines > maxLogLines {
+			message.Text = fmt.Sprintf("The number of lines must be between 1 and %d.", maxLogLines)
+			slack.postMessage(message)
+			return true
+		}
+	}
+
+	err = s.postLog(slack, message, args[0], args[1], strings.Join(args[2:], " "), lines)
+	if err != nil {
+		taskLog(s, message).error("cannot post the build log", "err", err)
+	}
+	return true
+}
+
+// postLog posts the log of a step in the thread of the message,
+// the first failed step if no step is named
+func (s WerckerLogs) postLog(slack chatAgent, message Message, arg, id, stepName string, lines int) error {
+	reply := message.inThread()
+	org, app := werckerApp(message, arg)
+	client := werckerClient(message.Workspace)
+
+	build, err := client.Build(id)
+
+	if err != nil {
+		reply.Text = "Sorry, I couldn't get build " + id + " from Wercker: " + err.Error()
+		slack.postMessage(reply)
+		return err
+	}
+
+	if build.Application != nil && werckerAppPath(*build.Application) != org+"/"+app {
+		reply.Text = fmt.Sprintf("Build `%s` belongs to %s, not %s/%s.", id, werckerAppPath(*build.Application), org, app)
+		return slack.postMessage(reply)
+	}
+
+	steps, err := client.BuildSteps(id)
+
+	if err != nil {
+		reply.Text = "Sorry, I couldn't get the steps of build " + id + " from Wercker: " + err.Error()
+		slack.postMessage(reply)
+		return err
+	}
+
+	step, ok := findStep(steps, stepName)
+
+	if !ok {
+		var names []string
+		for _, step := range steps {
+			names = append(names, "`"+step.Name+"`")
+		}
+
+		reply.Text = fmt.Sprintf("Build `%s` has no step %q. Its steps are %s.", id, stepName, strings.Join(names, ", "))
+		if len(names) == 0 {
+			reply.Text = fmt.Sprintf("Build `%s` has no steps yet.", id)
+		}
+		return slack.postMessage(reply)
+	}
+
+	log, err := client.StepLog(step)
+
+	if err != nil {
+		reply.Text = fmt.Sprintf("Sorry, I couldn't get the log of step `%s` from Wercker: %s", step.Name, err.Error())
+		slack.postMessage(reply)
+		return err
+	}
+
+	excerpt, from, total := extractLog(log, lines)
+
+	if total == 0 {
+		reply.Text = fmt.Sprintf("The log of step `%s` of build `%s` is empty.", step.Name, id)
+		return slack.postMessage(reply)
+	}
+
+	reply.Text = fmt.Sprintf("Step `%s` of build `%s` (%s), lines %d to %d of %d:\n```\n%s\n```",
+		step.Name, id, buildState(step.Status, step.Result), from+1, from+len(excerpt), total, strings.Join(excerpt, "\n"))
+
+	return slack.postMessage(reply)
+}
+
+// findStep returns the step with the given name,
+// without name the first step that failed or the last step
+func findStep(steps []wercker.Step, name string) (wercker.Step, bool) {
+	if len(steps) == 0 {
+		return wercker.Step{}, false
+	}
+
+	if name != "" {
+		for _, step := range steps {
+			if strings.EqualFold(step.Name, name) {
+				return step, true
+			}
+		}
+		return wercker.Step{}, false
+	}
+
+	for _, step := range steps {
+		if step.Result == "failed" {
+			return step, true
+		}
+	}
+
+	return steps[len(steps)-1], true
+}
+
+// extractLog keeps at most n lines of a log: the lines around the first error
+// if the log has one, its last lines otherwise
+// Returns the lines, the index of the first one and the number of lines in the log
+func extractLog(log string, n int) ([]string, int, int) {
+	log = ansiPattern.ReplaceAllString(log, "")
+	log = strings.Replace(log, "\r\n", "\n", -1)
+	lines := strings.Split(strings.TrimRight(log, "\n"), "\n")
+
+	if len(lines) == 1 && strings.TrimSpace(lines[0]) == "" {
+		return nil, 0, 0
+	}
+
+	from := len(lines) - n
+
+	for i, line := range lines {
+		if errorPattern.MatchString(line) {
+			from = i - logContext
+			if from > len(lines)-n {
+				from = len(lines) - n
+			}
+			break
+		}
+	}
+
+	if from < 0 {
+		from = 0
+	}
+
+	to := from + n
+	if to > len(lines) {
+		to = len(lines)
+	}
+
+	excerpt := make([]string, 0, to-from)
+
+	for _, line := range lines[from:to] {
+		if runes := []rune(line); len(runes) > maxLogLineLength {
+			line = string(runes[:maxLogLineLength]) + "…"
+		}
+		// a log must not close the code block
+		excerpt = append(excerpt, strings.Replace(line, "```", "`\u200b``", -1))
+	}
+
+	return excerpt, from, len(lines)
+}
+
+func (s WerckerLogs) Help(slack chatAgent, message Message) error {
+	message.Text = `<logs> posts the log of a step of a Wercker build in a thread.
+Usage:
+- @mario logs <app> <build id>
+- @mario logs <app> <build id> <step> [--lines <number>]
+
+Without a step, I post the log of the first step that failed.
+I post the lines around the first error, or the last lines of the log (30 by default).
+`
+
+	err := slack.postMessage(message)
+
+	if err != nil {
+		taskLog(s, message).error("cannot post the help", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s WerckerLogs) getName() string {
+	return "logs"
+}
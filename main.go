@@ -1,74 +1,292 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"github.com/umbrellium/mario/Godeps/_workspace/src/golang.org/x/net/websocket"
+	"io"
 	"log"
 	"os"
 	"strings"
+	"time"
 )
 
+var adapterName = flag.String("adapter", "", "the chat adapter Mario runs on, slack by default")
+
+// chosenAdapter returns the name of the adapter Mario runs on with a configuration:
+// --adapter, then the adapter setting
+func chosenAdapter(c *loadedConfig) string {
+	if *adapterName != "" {
+		return *adapterName
+	}
+
+	if name := c.setting("", "ADAPTER"); name != "" {
+		return name
+	}
+
+	return "slack"
+}
+
 func main() {
+	flag.Parse()
+
+	// what libraries log with the log package becomes structured entries too
+	log.SetFlags(0)
+	log.SetOutput(stdLogWriter{})
+
+	// mario config check
+	if flag.Arg(0) == "config" {
+		if flag.Arg(1) != "check" || flag.NArg() > 2 {
+			fmt.Println("Usage: mario [--config=<file>] [--adapter=<adapter>] config check")
+			os.Exit(2)
+		}
+
+		os.Exit(checkConfig())
+	}
+
+	err := loadConfig()
+
+	if err != nil {
+		logs.fatal("cannot load the configuration", "err", err)
+	}
+
+	adapter := chosenAdapter(config())
+
+	if errs := validateConfig(config(), adapter); len(errs) > 0 {
+		for _, err := range errs {
+			logs.error("invalid configuration", "err", err)
+		}
+		logs.fatal("run mario config check after fixing the configuration")
+	}
+
+	// instantiate the chat adapters
+	chats, err := adapters[adapter]()
+
+	if err != nil {
+		logs.fatal("cannot create the chat adapter", "adapter", adapter, "err", err)
+	}
+
+	logs.info("running Mario, press ctrl+C to stop it", "adapter", adapter, "config", config().path)
+
+	// record the Slack traffic to reproduce it later with --adapter=replay
+	if *recordPath != "" {
+		file, err := os.OpenFile(*recordPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
 
-	// instantiate slack
-	var s Slack
+		if err != nil {
+			logs.fatal("cannot open the recording", "path", *recordPath, "err", err)
+		}
 
-	fmt.Println("Running Mario. Press ctrl+C to stop it")
+		defer file.Close()
 
-	// slack token must be set as environmet var or passed as command line
-	token := os.Getenv("TOKEN")
+		for _, chat := range chats {
+			if slack, ok := chat.(*Slack); ok {
+				slack.Recorder = newRecorder(file)
+			}
+		}
+	}
+
+	// connect to the chat services
+	for _, chat := range chats {
+		health.connecting(adapter, chat)
+	}
 
-	if token == "" {
-		token = os.Args[1]
-		if token == "" {
-			log.Fatal("You must pass a token to connect to Slack")
+	for _, chat := range chats {
+		err = chat.connect()
+
+		if err != nil {
+			logs.fatal("cannot connect", "adapter", adapter, "err", err)
+		}
+
+		health.connected(chat)
+		logs.info("connected", "adapter", adapter, "workspace", workspaceOf(chat))
+
+		// the replies to the pings show the connection is alive
+		if p, ok := chat.(pinger); ok {
+			jobs.every("ping "+connectionName(adapter, chat), pingInterval, p.ping)
 		}
 	}
 
-	var ws *websocket.Conn
-	// connect to slack
-	ws, marioID, err := connectToSlack(token)
+	// remember what Mario knows across restarts,
+	// a replay keeps what it learns in memory
+	if adapter != "replay" {
+		err = openBrain()
+	}
+
+	if err != nil {
+		logs.fatal("cannot open the brain", "err", err)
+	}
+
+	defer brainStore.Close()
+	closeBrainOnSignal()
 
-	// pass websocket to slack struct
-	s.Socket = ws
+	// announce the Wercker builds and deploys of the watched apps
+	err = startWerckerWatchers(chats)
 
 	if err != nil {
-		log.Fatal(err)
+		logs.fatal("cannot watch the Wercker apps", "err", err)
+	}
+
+	// stop the deploys of the locked apps
+	startLocks(chats)
+
+	// receive the build and deploy events pushed by Wercker
+	webhook, err := handleWerckerWebhooks(chats)
+
+	if err != nil {
+		logs.fatal("cannot receive the Wercker webhooks", "err", err)
+	}
+
+	// let other services post messages and inspect Mario
+	handleAdminAPI(chats, adapter)
+
+	// show what Mario can do and what he's been doing
+	handleDashboard(chats, adapter)
+
+	// apply the changes of the configuration without restarting
+	watchConfig(chats, adapter)
+
+	if addr := httpAddr(); addr != "" {
+		err = startHTTPServer(addr)
+
+		if err != nil {
+			logs.fatal("cannot serve HTTP", "addr", addr, "err", err)
+		}
+	} else if webhook {
+		logs.fatal("set HTTP_ADDR or PORT to receive the Wercker webhooks")
+	}
+
+	// every connection runs on its own, a lost connection is reconnected
+	// while the others carry on, Mario stops once all of them stopped
+	stopped := make(chan error, len(chats))
+
+	for _, chat := range chats {
+		go func(chat chatAdapter) {
+			stopped <- keepRunning(adapter, chat)
+		}(chat)
+	}
+
+	for range chats {
+		<-stopped
+	}
+
+	logs.info("stopped")
+}
+
+// how long Mario waits before reconnecting a lost connection,
+// it doubles after every failed attempt
+var reconnectDelay = 5 * time.Second
+
+// keepRunning runs a chat connection and reconnects it whenever it's lost
+// Returns when the adapter has no more messages, e.g. at the end of a replay
+func keepRunning(adapter string, chat chatAdapter) error {
+	for {
+		err := run(chat)
+
+		if err == io.EOF {
+			return err
+		}
+
+		logs.error("lost the chat connection", "adapter", adapter, "workspace", workspaceOf(chat), "err", err)
+		reconnect(adapter, chat)
+	}
+}
+
+// reconnect connects a chat adapter again, until it succeeds
+func reconnect(adapter string, chat chatAdapter) {
+	delay := reconnectDelay
+
+	for {
+		time.Sleep(delay)
+
+		err := chat.connect()
+
+		if err == nil {
+			reconnects.inc(adapter)
+			health.connected(chat)
+			logs.info("reconnected", "adapter", adapter, "workspace", workspaceOf(chat))
+			return
+		}
+
+		health.disconnected(chat, err)
+		logs.warn("cannot reconnect", "adapter", adapter, "workspace", workspaceOf(chat), "err", err)
+
+		if delay *= 2; delay > 5*time.Minute {
+			delay = 5 * time.Minute
+		}
 	}
+}
 
+// run listens to the chat adapter and dispatches the messages addressed to Mario
+// Returns when the adapter cannot provide any more messages
+func run(chat chatAdapter) error {
 	for {
-		message, err := s.getMessage()
+		message, err := chat.getMessage()
 
 		if err != nil {
-			log.Fatal(err)
+			health.disconnected(chat, err)
+			return err
 		}
 
-		// parse message and act accordingly
-		if message.Type == "message" && strings.HasPrefix(message.Text, "<@"+marioID+">") {
-			text := strings.TrimPrefix(message.Text, "<@"+marioID+"> ")
-			text = strings.TrimSpace(text)
+		health.event(chat)
 
-			messageHandled := false
+		err = dispatch(chat, message)
 
-			for _, task := range tasks {
-				// we are using text to perform a reg ex and decide which method to call
-				if task.Hear(&s, message, text) {
-					messageHandled = true
-					break
-				}
-			}
+		if err != nil {
+			return err
+		}
+	}
+}
 
-			// Mario cannot understand command
-			if messageHandled == false {
-				message.Text = `I don't understand what you are asking me to do.
-Please ensure that your message doesn't contain any spelling mistake.
-You can type '@mario help' to see a list of the available tasks I can perform.`
-				err := s.postMessage(message)
+// dispatch parses a message and asks each task whether it can act on it
+// every message addressed to Mario gets a correlation ID, logged with what Mario does with it
+// Returns an error if Mario couldn't reply
+func dispatch(chat chatAdapter, message Message) error {
+	if message.Type != "message" {
+		return nil
+	}
 
-				if err != nil {
-					log.Fatal(err)
-				}
-			}
+	messagesReceived.inc()
+
+	text, ok := chat.command(message)
+	if !ok {
+		return nil
+	}
+
+	text = strings.TrimSpace(text)
+
+	message.CorrelationID = newCorrelationID()
+	entry := messageLog(message)
+	entry.debug("received command", "text", text)
+
+	start := time.Now()
+
+	// Mario may be waiting for an answer to one of his questions
+	if conversations.answer(chat, message, text) {
+		commandsHandled.inc("conversation")
+		taskDuration.since(start, "conversation")
+		recordCommand(message, text, "conversation", start)
+		entry.info("answered question", "duration", time.Since(start))
+		return nil
+	}
+
+	for _, task := range tasks {
+		// we are using text to perform a reg ex and decide which method to call
+		if task.Hear(chat, message, text) {
+			commandsHandled.inc(task.getName())
+			taskDuration.since(start, task.getName())
+			recordCommand(message, text, task.getName(), start)
+			entry.info("handled command", "task", task.getName(), "duration", time.Since(start))
+			return nil
 		}
 	}
+
+	commandsUnknown.inc()
+	recordCommand(message, text, "", start)
+	entry.info("unhandled command", "duration", time.Since(start))
+
+	// Mario cannot understand command
+	message.Text = `I don't understand what you are asking me to do.
+Please ensure that your message doesn't contain any spelling mistake.
+You can type '@mario help' to see a list of the available tasks I can perform.`
+
+	return chat.postMessage(message)
 }
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/umbrellium/mario/Godeps/_workspace/src/golang.org/x/net/websocket"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Mattermost is a chat adapter for Mattermost servers
+// it listens to the websocket event stream and posts with the REST API
+type Mattermost struct {
+	// URL of the Mattermost server, e.g. https://chat.example.com
+	URL string
+	// Token is a personal access token of Mario's account
+	Token  string
+	Socket *websocket.Conn
+
+	// Mario's Mattermost user ID and username, set when connecting
+	ID       string
+	Username string
+
+	// direct message channels, every message posted there is addressed to Mario
+	mu     sync.Mutex
+	direct map[string]bool
+	// the usernames Mario looked up, by user ID
+	names map[string]string
+
+	// seq numbers the actions sent on the websocket
+	seq uint64
+}
+
+// mattermostEvent is an event received from the Mattermost websocket
+// replies to Mario's actions have no event but a seq_reply
+type mattermostEvent struct {
+	Event    string `json:"event"`
+	SeqReply uint64 `json:"seq_reply"`
+	Data     struct {
+		// the post is itself JSON encoded
+		Post        string `json:"post"`
+		ChannelType string `json:"channel_type"`
+	} `json:"data"`
+}
+
+// mattermostPost is a Mattermost message
+type mattermostPost struct {
+	Id        string `json:"id,omitempty"`
+	UserId    string `json:"user_id,omitempty"`
+	ChannelId string `json:"channel_id"`
+	Message   string `json:"message"`
+	RootId    string `json:"root_id,omitempty"`
+	Type      string `json:"type,omitempty"`
+}
+
+type mattermostUser struct {
+	Id       string `json:"id"`
+	Username string `json:"username"`
+}
+
+// newMattermostAdapter creates a Mattermost adapter from the MATTERMOST_* settings
+// Returns the adapter and an error
+func newMattermostAdapter() (chatAdapter, error) {
+	server := workspaceSetting("", "MATTERMOST_URL")
+	token := workspaceSetting("", "MATTERMOST_TOKEN")
+
+	if server == "" || token == "" {
+		return nil, fmt.Errorf("You must set mattermost.url and mattermost.token, or MATTERMOST_URL and MATTERMOST_TOKEN, to connect to Mattermost")
+	}
+
+	return &Mattermost{URL: server, Token: token}, nil
+}
+
+// Connect finds out who Mario is and opens the Mattermost websocket
+func (m *Mattermost) connect() error {
+	m.URL = strings.TrimSuffix(m.URL, "/")
+
+	// the direct message channels are still known after reconnecting
+	m.mu.Lock()
+	if m.direct == nil {
+		m.direct = map[string]bool{}
+	}
+	m.mu.Unlock()
+
+	return m.dial()
+}
+
+// dial opens and authenticates the websocket
+func (m *Mattermost) dial() error {
+	var me mattermostUser
+	err := m.api("GET", "/users/me", nil, &me)
+
+	if err != nil {
+		return err
+	}
+
+	m.ID = me.Id
+	m.Username = me.Username
+
+	wsURL := "ws" + strings.TrimPrefix(m.URL, "http") + "/api/v4/websocket"
+	config, err := websocket.NewConfig(wsURL, m.URL)
+
+	if err != nil {
+		return err
+	}
+
+	config.Header.Set("Authorization", "Bearer "+m.Token)
+	socket, err := websocket.DialConfig(config)
+
+	if err != nil {
+		return fmt.Errorf("Error: cannot open mattermost websocket: %v", err)
+	}
+
+	m.mu.Lock()
+	m.Socket = socket
+	m.mu.Unlock()
+
+	// authenticate the websocket, older servers ignore the header
+	challenge := map[string]interface{}{
+		"seq":    atomic.AddUint64(&m.seq, 1),
+		"action": "authentication_challenge",
+		"data":   map[string]string{"token": m.Token},
+	}
+
+	return websocket.JSON.Send(socket, challenge)
+}
+
+// socket returns the current websocket, it changes when Mario reconnects
+func (m *Mattermost) socket() *websocket.Conn {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Socket
+}
+
+// ping asks Mattermost for a pong, the pongs show the connection is alive
+func (m *Mattermost) ping() error {
+	return websocket.JSON.Send(m.socket(), map[string]interface{}{"seq": atomic.AddUint64(&m.seq, 1), "action": "ping"})
+}
+
+// GetMessage listens to the Mattermost websocket
+// Returns the message, the other events typed by their name, the replies typed reply,
+// or an error once the websocket is closed
+func (m *Mattermost) getMessage() (Message, error) {
+	var msg Message
+
+	for {
+		var raw []byte
+		err := websocket.Message.Receive(m.socket(), &raw)
+
+		if err != nil {
+			m.socket().Close()
+			return msg, fmt.Errorf("Error: lost connection to Mattermost: %v", err)
+		}
+
+		// the data of other events may have a different shape
+		var event mattermostEvent
+		if json.Unmarshal(raw, &event) != nil {
+			continue
+		}
+
+		switch {
+		case event.Event == "" && event.SeqReply > 0:
+			msg.Type = "reply"
+			return msg, nil
+		case event.Event != "posted":
+			msg.Type = event.Event
+			return msg, nil
+		}
+
+		var post mattermostPost
+		err = json.Unmarshal([]byte(event.Data.Post), &post)
+
+		// ignore Mario's own posts and system messages
+		if err != nil || post.UserId == m.ID || post.Type != "" {
+			msg.Type = "posted"
+			return msg, nil
+		}
+
+		if event.Data.ChannelType == "D" {
+			m.mu.Lock()
+			m.direct[post.ChannelId] = true
+			m.mu.Unlock()
+		}
+
+		msg.Type = "message"
+		msg.Channel = post.ChannelId
+		msg.User = post.UserId
+		msg.Text = post.Message
+		msg.Ts = post.Id
+		msg.ThreadTs = post.RootId
+		return msg, nil
+	}
+}
+
+// PostMessage publishes a message on Mattermost
+// Returns an error if it couldn't complete the operation
+func (m *Mattermost) postMessage(msg Message) error {
+	msg = outgoingMessage(msg)
+	post := mattermostPost{ChannelId: msg.Channel, Message: msg.Text, RootId: msg.ThreadTs}
+	return m.api("POST", "/posts", post, nil)
+}
+
+// messages addressed to Mario start with his mention, e.g. "@mario hello"
+// direct messages are always addressed to Mario
+func (m *Mattermost) command(msg Message) (string, bool) {
+	m.mu.Lock()
+	direct := m.direct[msg.Channel]
+	m.mu.Unlock()
+
+	mention := "@" + strings.ToLower(m.Username)
+	text := strings.ToLower(msg.Text)
+
+	if strings.HasPrefix(text, mention) {
+		rest := msg.Text[len(mention):]
+		if rest == "" || rest[0] == ' ' || rest[0] == ':' || rest[0] == ',' {
+			return strings.TrimLeft(rest, ":,"), true
+		}
+	}
+
+	if direct {
+		return msg.Text, true
+	}
+
+	return "", false
+}
+
+// userName returns the username of a user, looked up once,
+// or the user ID if it cannot be found
+func (m *Mattermost) userName(id string) string {
+	m.mu.Lock()
+	name, ok := m.names[id]
+	m.mu.Unlock()
+
+	if ok {
+		return name
+	}
+
+	var user mattermostUser
+
+	if err := m.api("GET", "/users/"+url.PathEscape(id), nil, &user); err != nil || user.Username == "" {
+		logs.warn("cannot find the Mattermost user", "user", id, "err", err)
+		return id
+	}
+
+	m.mu.Lock()
+	if m.names == nil {
+		m.names = map[string]string{}
+	}
+	m.names[id] = user.Username
+	m.mu.Unlock()
+
+	return user.Username
+}
+
+// api calls the Mattermost REST API
+// the response is decoded into result, unless it is nil
+// Returns an error if the request failed
+func (m *Mattermost) api(method, path string, body interface{}, result interface{}) error {
+	var payload bytes.Buffer
+
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, m.URL+"/api/v4"+path, &payload)
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+m.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := apiClient("mattermost").Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		var apiError struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(res.Body).Decode(&apiError)
+		return fmt.Errorf("Error: mattermost %s %s returned %s: %s", method, path, res.Status, apiError.Message)
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return json.NewDecoder(res.Body).Decode(result)
+}
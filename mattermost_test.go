@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/umbrellium/mario/Godeps/_workspace/src/golang.org/x/net/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFakeMattermost starts a local stub of the Mattermost API
+// events sent to the returned channel are pushed to Mario's websocket
+// and posts created by Mario are sent to the posts channel
+func newFakeMattermost(t *testing.T) (*httptest.Server, chan<- string, <-chan mattermostPost) {
+	events := make(chan string, 8)
+	posts := make(chan mattermostPost, 8)
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/v4/users/me", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"id":"mario-id","username":"mario"}`))
+	})
+
+	mux.HandleFunc("/api/v4/users/alice-id", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"alice-id","username":"alice"}`))
+	})
+
+	mux.HandleFunc("/api/v4/posts", func(w http.ResponseWriter, r *http.Request) {
+		var post mattermostPost
+		json.NewDecoder(r.Body).Decode(&post)
+		posts <- post
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{}`))
+	})
+
+	mux.Handle("/api/v4/websocket", websocket.Handler(func(ws *websocket.Conn) {
+		var challenge struct {
+			Action string            `json:"action"`
+			Data   map[string]string `json:"data"`
+		}
+		websocket.JSON.Receive(ws, &challenge)
+
+		if challenge.Action != "authentication_challenge" || challenge.Data["token"] != "secret" {
+			t.Errorf("Expected Mario to authenticate the websocket, got %+v", challenge)
+			return
+		}
+
+		websocket.Message.Send(ws, `{"status":"OK","seq_reply":1}`)
+		websocket.Message.Send(ws, `{"event":"hello","data":{"server_version":"5.0"}}`)
+
+		// pings are answered with a pong
+		go func() {
+			var action struct {
+				Seq    uint64 `json:"seq"`
+				Action string `json:"action"`
+			}
+			for websocket.JSON.Receive(ws, &action) == nil {
+				if action.Action == "ping" {
+					websocket.JSON.Send(ws, map[string]interface{}{"status": "OK", "seq_reply": action.Seq, "data": map[string]string{"text": "pong"}})
+				}
+			}
+		}()
+
+		// a close event ends the connection, Mario opens a new one
+		for event := range events {
+			if event == "close" {
+				return
+			}
+			websocket.Message.Send(ws, event)
+		}
+	}))
+
+	return httptest.NewServer(mux), events, posts
+}
+
+// postedEvent builds a posted websocket event
+func postedEvent(post mattermostPost, channelType string) string {
+	encodedPost, _ := json.Marshal(post)
+	event, _ := json.Marshal(map[string]interface{}{
+		"event": "posted",
+		"data": map[string]interface{}{
+			"post":         string(encodedPost),
+			"channel_type": channelType,
+			"mentions":     `["mario-id"]`,
+			"set_online":   true,
+		},
+	})
+	return string(event)
+}
+
+// nextMessage reads the Mattermost events until someone posts a message
+func nextMessage(m *Mattermost) (Message, error) {
+	for {
+		msg, err := m.getMessage()
+		if err != nil || msg.Type == "message" {
+			return msg, err
+		}
+	}
+}
+
+// test mention detection
+func TestMattermostCommand(t *testing.T) {
+	mattermost := &Mattermost{Username: "mario", direct: map[string]bool{"dm": true}}
+
+	type mattermostTestingStruct struct {
+		msg      Message
+		expected string
+		ok       bool
+	}
+
+	mattermostCommandTest := []mattermostTestingStruct{
+		{Message{Channel: "town-square", Text: "@mario hello"}, " hello", true},
+		{Message{Channel: "town-square", Text: "@Mario: hello"}, " hello", true},
+		{Message{Channel: "town-square", Text: "@marios hello"}, "", false},
+		{Message{Channel: "town-square", Text: "hello @mario"}, "", false},
+		{Message{Channel: "dm", Text: "hello"}, "hello", true},
+	}
+
+	for _, tst := range mattermostCommandTest {
+		res, ok := mattermost.command(tst.msg)
+		if res != tst.expected || ok != tst.ok {
+			t.Errorf("Expected %q to return %q %t, got %q %t instead", tst.msg.Text, tst.expected, tst.ok, res, ok)
+		}
+	}
+}
+
+// test a session against the stub server
+func TestMattermostSession(t *testing.T) {
+	server, events, posts := newFakeMattermost(t)
+	defer server.Close()
+	defer close(events)
+
+	mattermost := &Mattermost{URL: server.URL + "/", Token: "secret"}
+
+	if err := mattermost.connect(); err != nil {
+		t.Fatalf("Expected connect to return no error, got %v", err)
+	}
+
+	if mattermost.ID != "mario-id" || mattermost.Username != "mario" {
+		t.Fatalf("Expected Mario to know who he is, got %q %q", mattermost.ID, mattermost.Username)
+	}
+
+	// Mario's own posts and system messages are ignored
+	events <- postedEvent(mattermostPost{UserId: "mario-id", ChannelId: "town-square", Message: "Yo!"}, "O")
+	events <- postedEvent(mattermostPost{UserId: "alice-id", ChannelId: "town-square", Message: "alice joined", Type: "system_join_channel"}, "O")
+	events <- postedEvent(mattermostPost{UserId: "alice-id", ChannelId: "town-square", Message: "@mario hello"}, "O")
+
+	msg, err := nextMessage(mattermost)
+	if err != nil {
+		t.Fatalf("Expected getMessage to return no error, got %v", err)
+	}
+
+	if msg.User != "alice-id" || msg.Channel != "town-square" || msg.Text != "@mario hello" {
+		t.Fatalf("Expected alice's message, got %+v", msg)
+	}
+
+	if err := dispatch(mattermost, msg); err != nil {
+		t.Fatalf("Expected dispatch to return no error, got %v", err)
+	}
+
+	select {
+	case post := <-posts:
+		if post.ChannelId != "town-square" || post.Message != "Yo!" {
+			t.Errorf("Expected Mario to post Yo! in town-square, got %+v", post)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("Expected Mario to post a reply")
+	}
+
+	if name := mattermost.userName("alice-id"); name != "alice" {
+		t.Errorf("Expected alice-id to be alice, got %q", name)
+	}
+
+	if name := mattermost.userName("bob-id"); name != "bob-id" {
+		t.Errorf("Expected an unknown user to keep its ID, got %q", name)
+	}
+
+	// getMessage fails when the websocket is closed, main reconnects the adapter
+	events <- "close"
+
+	if _, err = nextMessage(mattermost); err == nil || !strings.Contains(err.Error(), "lost connection to Mattermost") {
+		t.Fatalf("Expected getMessage to fail once the websocket is closed, got %v", err)
+	}
+
+	if err := mattermost.connect(); err != nil {
+		t.Fatalf("Expected connect to return no error, got %v", err)
+	}
+
+	events <- postedEvent(mattermostPost{UserId: "bob-id", ChannelId: "town-square", Message: "@mario hello"}, "O")
+
+	if msg, err = nextMessage(mattermost); err != nil || msg.User != "bob-id" {
+		t.Errorf("Expected bob's message after reconnecting, got %+v, %v", msg, err)
+	}
+}
+
+// test the pongs are read like the other events
+func TestMattermostPing(t *testing.T) {
+	server, events, _ := newFakeMattermost(t)
+	defer server.Close()
+	defer close(events)
+
+	mattermost := &Mattermost{URL: server.URL, Token: "secret"}
+
+	if err := mattermost.connect(); err != nil {
+		t.Fatalf("Expected connect to return no error, got %v", err)
+	}
+
+	for _, expected := range []string{"reply", "hello"} {
+		msg, err := mattermost.getMessage()
+		if err != nil || msg.Type != expected {
+			t.Fatalf("Expected the %s event, got %+v, %v", expected, msg, err)
+		}
+	}
+
+	if err := mattermost.ping(); err != nil {
+		t.Fatalf("Expected ping to return no error, got %v", err)
+	}
+
+	if msg, err := mattermost.getMessage(); err != nil || msg.Type != "reply" {
+		t.Errorf("Expected the pong, got %+v, %v", msg, err)
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metric is a family of samples exposed on /metrics
+// in the Prometheus text format
+type metric interface {
+	write(w io.Writer)
+}
+
+var (
+	metricsMu sync.Mutex
+	// every metric Mario exposes, in the order they were registered
+	registeredMetrics []metric
+)
+
+// register adds a metric to /metrics
+func register(m metric) {
+	metricsMu.Lock()
+	registeredMetrics = append(registeredMetrics, m)
+	metricsMu.Unlock()
+}
+
+// metricFamily holds what every metric has: a name, a help text,
+// the names of its labels and the samples of each combination of label values
+type metricFamily struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu sync.Mutex
+}
+
+// labelEscaper escapes label values as the text format expects
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// key identifies a combination of label values, joined by \xff
+func (f *metricFamily) key(values []string) string {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", f.name, len(f.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// header writes the HELP and TYPE lines
+func (f *metricFamily) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+}
+
+// labelPairs formats the labels of a sample, e.g. {task="deploy"}
+// extra is added after the labels, e.g. le="0.5" for a histogram bucket
+func (f *metricFamily) labelPairs(key string, extra ...string) string {
+	var pairs []string
+
+	if len(f.labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, f.labels[i]+`="`+labelEscaper.Replace(value)+`"`)
+		}
+	}
+
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+labelEscaper.Replace(extra[i+1])+`"`)
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// sortedKeys returns the label values of the samples in order
+func sortedKeys(values map[string]float64) []string {
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatFloat writes a sample value like Prometheus does
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// counterVec counts events, e.g. the commands handled by each task
+type counterVec struct {
+	metricFamily
+	values map[string]float64
+}
+
+// newCounter registers a counter, a counter without labels starts at 0
+func newCounter(name, help string, labels ...string) *counterVec {
+	c := &counterVec{metricFamily: metricFamily{name: name, help: help, kind: "counter", labels: labels}, values: map[string]float64{}}
+	if len(labels) == 0 {
+		c.values[""] = 0
+	}
+	register(c)
+	return c
+}
+
+// inc adds one to the counter of the label values
+func (c *counterVec) inc(values ...string) {
+	key := c.key(values)
+
+	c.mu.Lock()
+	c.values[key]++
+	c.mu.Unlock()
+}
+
+// value returns the counter of the label values
+func (c *counterVec) value(values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[c.key(values)]
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.header(w)
+
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(key), formatFloat(c.values[key]))
+	}
+}
+
+// gaugeVec is a value that goes up and down, e.g. the length of a queue
+type gaugeVec struct {
+	metricFamily
+	values map[string]float64
+}
+
+// newGauge registers a gauge
+func newGauge(name, help string, labels ...string) *gaugeVec {
+	g := &gaugeVec{metricFamily: metricFamily{name: name, help: help, kind: "gauge", labels: labels}, values: map[string]float64{}}
+	register(g)
+	return g
+}
+
+// set changes the gauge of the label values
+func (g *gaugeVec) set(value float64, values ...string) {
+	key := g.key(values)
+
+	g.mu.Lock()
+	g.values[key] = value
+	g.mu.Unlock()
+}
+
+func (g *gaugeVec) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.header(w)
+
+	for _, key := range sortedKeys(g.values) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelPairs(key), formatFloat(g.values[key]))
+	}
+}
+
+// defaultBuckets are the upper bounds of the histograms, in seconds
+var defaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// histogramVec counts observations, e.g. durations, in buckets
+type histogramVec struct {
+	metricFamily
+	buckets []float64
+	samples map[string]*histogramSample
+}
+
+type histogramSample struct {
+	// counts[i] is the number of observations <= buckets[i]
+	counts []float64
+	count  float64
+	sum    float64
+}
+
+// newHistogram registers a histogram with the default buckets
+func newHistogram(name, help string, labels ...string) *histogramVec {
+	h := &histogramVec{
+		metricFamily: metricFamily{name: name, help: help, kind: "histogram", labels: labels},
+		buckets:      defaultBuckets,
+		samples:      map[string]*histogramSample{},
+	}
+	register(h)
+	return h
+}
+
+// observe counts a value for the label values
+func (h *histogramVec) observe(value float64, values ...string) {
+	key := h.key(values)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sample, ok := h.samples[key]
+	if !ok {
+		sample = &histogramSample{counts: make([]float64, len(h.buckets))}
+		h.samples[key] = sample
+	}
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			sample.counts[i]++
+		}
+	}
+
+	sample.count++
+	sample.sum += value
+}
+
+// since observes the seconds elapsed since start
+func (h *histogramVec) since(start time.Time, values ...string) {
+	h.observe(time.Since(start).Seconds(), values...)
+}
+
+// count returns the number of observations of the label values
+func (h *histogramVec) count(values ...string) float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if sample, ok := h.samples[h.key(values)]; ok {
+		return sample.count
+	}
+	return 0
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.header(w)
+
+	var keys []string
+	for key := range h.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		sample := h.samples[key]
+
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %s\n", h.name, h.labelPairs(key, "le", formatFloat(bound)), formatFloat(sample.counts[i]))
+		}
+
+		fmt.Fprintf(w, "%s_bucket%s %s\n", h.name, h.labelPairs(key, "le", "+Inf"), formatFloat(sample.count))
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(key), formatFloat(sample.sum))
+		fmt.Fprintf(w, "%s_count%s %s\n", h.name, h.labelPairs(key), formatFloat(sample.count))
+	}
+}
+
+// Mario's metrics
+var (
+	messagesReceived = newCounter("mario_messages_received_total", "Messages received from the chat services.")
+	commandsHandled  = newCounter("mario_commands_handled_total", "Commands addressed to Mario that a task handled.", "task")
+	commandsUnknown  = newCounter("mario_commands_unhandled_total", "Commands addressed to Mario that no task understood.")
+	messagesPosted   = newCounter("mario_messages_posted_total", "Messages posted by Mario.")
+	errorsLogged     = newCounter("mario_errors_total", "Errors logged by Mario.")
+	reconnects       = newCounter("mario_reconnects_total", "Reconnections to the chat service.", "adapter")
+	taskDuration     = newHistogram("mario_task_duration_seconds", "Time taken by the tasks to handle a command.", "task")
+	apiDuration      = newHistogram("mario_api_request_duration_seconds", "Duration of the requests to external APIs.", "service")
+	queueDepth       = newGauge("mario_queue_depth", "Messages waiting to be sent.", "queue")
+)
+
+// instrumentedTransport times the requests sent to an external API
+type instrumentedTransport struct {
+	service string
+	next    http.RoundTripper
+}
+
+func (t instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	defer apiDuration.since(time.Now(), t.service)
+	return t.next.RoundTrip(req)
+}
+
+// how long Mario waits for an API request, reading the response included
+var apiTimeout = 30 * time.Second
+
+// apiClient returns an HTTP client whose requests are timed in apiDuration
+// and give up after apiTimeout
+func apiClient(service string) *http.Client {
+	return &http.Client{Timeout: apiTimeout, Transport: instrumentedTransport{service: service, next: http.DefaultTransport}}
+}
+
+// serveMetrics writes every metric in the Prometheus text format
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	metricsMu.Lock()
+	metrics := append([]metric{}, registeredMetrics...)
+	metricsMu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+func init() {
+	httpMux.HandleFunc("/metrics", serveMetrics)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// test the text format of each kind of metric
+func TestMetricsFormat(t *testing.T) {
+	counter := &counterVec{metricFamily: metricFamily{name: "test_total", help: "A test counter.", kind: "counter", labels: []string{"task"}}, values: map[string]float64{}}
+	counter.inc("deploy")
+	counter.inc("deploy")
+	counter.inc(`say "hi"`)
+
+	gauge := &gaugeVec{metricFamily: metricFamily{name: "test_depth", help: "A test gauge.", kind: "gauge"}, values: map[string]float64{}}
+	gauge.set(3)
+
+	histogram := &histogramVec{metricFamily: metricFamily{name: "test_seconds", help: "A test histogram.", kind: "histogram", labels: []string{"service"}},
+		buckets: []float64{0.1, 1}, samples: map[string]*histogramSample{}}
+	histogram.observe(0.05, "slack")
+	histogram.observe(0.5, "slack")
+	histogram.observe(2, "slack")
+
+	var out bytes.Buffer
+	for _, m := range []metric{counter, gauge, histogram} {
+		m.write(&out)
+	}
+
+	expected := `# HELP test_total A test counter.
+# TYPE test_total counter
+test_total{task="deploy"} 2
+test_total{task="say \"hi\""} 1
+# HELP test_depth A test gauge.
+# TYPE test_depth gauge
+test_depth 3
+# HELP test_seconds A test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{service="slack",le="0.1"} 1
+test_seconds_bucket{service="slack",le="1"} 2
+test_seconds_bucket{service="slack",le="+Inf"} 3
+test_seconds_sum{service="slack"} 2.55
+test_seconds_count{service="slack"} 3
+`
+
+	if out.String() != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, out.String())
+	}
+}
+
+// test /metrics counts the commands and the API requests
+func TestMetricsEndpoint(t *testing.T) {
+	handled := commandsHandled.value("hello")
+	unhandled := commandsUnknown.value()
+
+	shell := &Shell{Out: &bytes.Buffer{}, User: "developer", Channel: "shell"}
+	dispatch(shell, Message{Type: "message", Channel: "shell", Text: "hello"})
+	dispatch(shell, Message{Type: "message", Channel: "shell", Text: "dance"})
+
+	if commandsHandled.value("hello") != handled+1 || commandsUnknown.value() != unhandled+1 {
+		t.Errorf("Expected a handled and an unhandled command, got %v and %v", commandsHandled.value("hello")-handled, commandsUnknown.value()-unhandled)
+	}
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer api.Close()
+
+	requests := apiDuration.count("test")
+
+	if _, err := apiClient("test").Get(api.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	if apiDuration.count("test") != requests+1 {
+		t.Errorf("Expected the API request to be timed, got %v", apiDuration.count("test")-requests)
+	}
+
+	server := httptest.NewServer(httpMux)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, _ := ioutil.ReadAll(res.Body)
+
+	for _, line := range []string{
+		"# TYPE mario_commands_handled_total counter",
+		`mario_commands_handled_total{task="hello"} `,
+		"mario_commands_unhandled_total ",
+		`mario_task_duration_seconds_count{task="hello"} `,
+		`mario_api_request_duration_seconds_count{service="test"} `,
+		"# TYPE mario_queue_depth gauge",
+	} {
+		if !strings.Contains(string(body), line) {
+			t.Errorf("Expected /metrics to contain %q, got\n%s", line, body)
+		}
+	}
+
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("Expected the text format, got %q", res.Header.Get("Content-Type"))
+	}
+}
+
+// test the API requests give up after apiTimeout
+func TestAPIClientTimeout(t *testing.T) {
+	previous := apiTimeout
+	apiTimeout = 10 * time.Millisecond
+	defer func() { apiTimeout = previous }()
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer api.Close()
+
+	if _, err := apiClient("test").Get(api.URL); err == nil {
+		t.Errorf("Expected a slow API to time out")
+	}
+}
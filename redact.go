@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// redacted replaces the secrets Mario hides
+const redacted = "[REDACTED]"
+
+// secretSettings hold secrets, their values are never logged, recorded or posted
+var secretSettings = []string{"TOKEN", "WERCKER_TOKEN", "IRC_PASSWORD", "MATTERMOST_TOKEN", "WERCKER_WEBHOOK_SECRET", "ADMIN_API_TOKEN", "DASHBOARD_PASSWORD"}
+
+// tokenPatterns match the secrets Mario doesn't know about
+var tokenPatterns = []*regexp.Regexp{
+	// Slack tokens
+	regexp.MustCompile(`xox[a-z]-[0-9A-Za-z-]+`),
+	regexp.MustCompile(`xapp-[0-9A-Za-z-]+`),
+	// GitHub tokens
+	regexp.MustCompile(`gh[pousr]_[0-9A-Za-z]{36,}`),
+	// AWS access keys
+	regexp.MustCompile(`AKIA[0-9A-Z]{16}`),
+	// Wercker tokens are 64 hexadecimal characters, commits only 40
+	regexp.MustCompile(`\b[0-9a-fA-F]{64}\b`),
+	// any token sent in an Authorization header
+	regexp.MustCompile(`(?i)bearer\s+[0-9A-Za-z._~+/=-]{16,}`),
+}
+
+// sha256 digests, e.g. of docker images, look like Wercker tokens but are kept
+const digestPrefix = "sha256:"
+
+var (
+	secretsMu sync.RWMutex
+	// secrets are the values of the secret settings, read when the configuration is loaded
+	secrets []string
+)
+
+func init() {
+	onReload(func() error {
+		refreshSecrets()
+		return nil
+	})
+}
+
+// knownSecrets returns the values of the secret settings
+func knownSecrets() []string {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	return secrets
+}
+
+// refreshSecrets reads the values of the secret settings again,
+// in the environment and in every workspace of the configuration
+func refreshSecrets() {
+	c := config()
+
+	workspaces := []string{""}
+	for workspace := range c.workspaces {
+		workspaces = append(workspaces, workspace)
+	}
+
+	var values []string
+
+	for _, name := range secretSettings {
+		for _, workspace := range workspaces {
+			values = append(values, c.setting(workspace, name))
+		}
+
+		// the settings of the workspaces that are only in the environment
+		for _, variable := range os.Environ() {
+			if parts := strings.SplitN(variable, "=", 2); strings.HasPrefix(parts[0], name+"_") {
+				values = append(values, parts[1])
+			}
+		}
+	}
+
+	var found []string
+
+	for _, value := range values {
+		// TOKEN lists a token per workspace, a short secret is redacted too,
+		// even if it redacts ordinary words
+		for _, secret := range strings.Split(value, ",") {
+			if secret = strings.TrimSpace(secret); secret != "" {
+				found = append(found, secret)
+			}
+		}
+	}
+
+	secretsMu.Lock()
+	secrets = found
+	secretsMu.Unlock()
+}
+
+// redact replaces the known secrets and anything that looks like a token in a text
+// Returns the redacted text
+func redact(text string) string {
+	return redactTokens(redactKnown(text))
+}
+
+// redactKnown replaces the values of the secret settings in a text
+func redactKnown(text string) string {
+	for _, secret := range knownSecrets() {
+		text = strings.Replace(text, secret, redacted, -1)
+	}
+	return text
+}
+
+// redactTokens replaces what looks like a token in a text
+func redactTokens(text string) string {
+	for _, pattern := range tokenPatterns {
+		var kept strings.Builder
+		last := 0
+
+		for _, match := range pattern.FindAllStringIndex(text, -1) {
+			if strings.HasSuffix(text[:match[0]], digestPrefix) {
+				continue
+			}
+
+			kept.WriteString(text[last:match[0]])
+			kept.WriteString(redacted)
+			last = match[1]
+		}
+
+		kept.WriteString(text[last:])
+		text = kept.String()
+	}
+
+	return text
+}
+
+// redactError removes the secrets from an error, e.g. a *url.Error with a token in its URL
+// Returns the error, or a new error with the same redacted message
+func redactError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if message := redact(err.Error()); message != err.Error() {
+		return errors.New(message)
+	}
+
+	return err
+}
+
+// containsSecret checks whether a text has a secret or something that looks like a token
+func containsSecret(text string) bool {
+	return redact(text) != text
+}
+
+// refusedMessage replaces a message Mario won't post
+const refusedMessage = "I won't post this message, it contains one of my secrets."
+
+// outgoingMessage is called by every adapter before posting a message:
+// it logs the message and stops Mario from echoing secrets into chat,
+// a message with one of Mario's secrets isn't posted, what only looks like a token is redacted
+// Returns the message to post
+func outgoingMessage(msg Message) Message {
+	entry := messageLog(msg)
+
+	if redactKnown(msg.Text) != msg.Text {
+		entry.warn("refused to post a message that contains a secret")
+		msg.Text = refusedMessage
+	} else if text := redactTokens(msg.Text); text != msg.Text {
+		entry.warn("redacted what looks like a token from a message")
+		msg.Text = text
+	}
+
+	entry.debug("posting message", "thread", msg.ThreadTs)
+	messagesPosted.inc()
+
+	return msg
+}
+
+// redactingWriter redacts what is written to out, e.g. the logs
+type redactingWriter struct {
+	out io.Writer
+}
+
+func (w redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.out, redact(string(p))); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
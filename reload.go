@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// settings that are only read when Mario starts,
+// changing them in the configuration file needs a restart
+var restartSettings = map[string]bool{
+	"ADAPTER":                true,
+	"BRAIN":                  true,
+	"HTTP_ADDR":              true,
+	"TOKEN":                  true,
+	"IRC_SERVER":             true,
+	"IRC_NICK":               true,
+	"IRC_CHANNELS":           true,
+	"IRC_PASSWORD":           true,
+	"IRC_TLS":                true,
+	"MATTERMOST_URL":         true,
+	"MATTERMOST_TOKEN":       true,
+	"WERCKER_WATCH_INTERVAL": true,
+	"WERCKER_WEBHOOK_SECRET": true,
+}
+
+// how often Mario checks whether the configuration file changed
+var configWatchInterval = 5 * time.Second
+
+var (
+	// reloadMu stops two reloads from running at once
+	reloadMu    sync.Mutex
+	reloadHooks []func() error
+)
+
+// onReload registers a function that applies the new configuration
+// to what was set up when Mario started, e.g. starts new watchers.
+// the functions run after every successful reload
+func onReload(fn func() error) {
+	reloadMu.Lock()
+	reloadHooks = append(reloadHooks, fn)
+	reloadMu.Unlock()
+}
+
+// configReload says what a reload changed
+type configReload struct {
+	path string
+	// changed lists the settings that changed, and restart those that need a restart
+	changed []string
+	restart []string
+}
+
+// String reports a reload to the admins, without the values of the settings
+func (r configReload) String() string {
+	path := r.path
+	if path == "" {
+		path = "the environment"
+	}
+
+	if len(r.changed) == 0 && len(r.restart) == 0 {
+		return fmt.Sprintf("Reloaded the configuration from %s, nothing changed", path)
+	}
+
+	report := fmt.Sprintf("Reloaded the configuration from %s", path)
+
+	if len(r.changed) > 0 {
+		report += ", changed " + strings.Join(r.changed, ", ")
+	}
+
+	if len(r.restart) > 0 {
+		report += ". Restart me to apply " + strings.Join(r.restart, ", ")
+	}
+
+	return report
+}
+
+// diffConfig lists the settings that differ between two configurations,
+// in any workspace
+func diffConfig(previous, next *loadedConfig) configReload {
+	workspaces := map[string]bool{"": true}
+	for _, c := range []*loadedConfig{previous, next} {
+		for workspace := range c.workspaces {
+			workspaces[workspace] = true
+		}
+	}
+
+	reload := configReload{path: next.path}
+
+	for _, setting := range configSettings {
+		for workspace := range workspaces {
+			if previous.setting(workspace, setting.name) == next.setting(workspace, setting.name) {
+				continue
+			}
+
+			if restartSettings[setting.name] {
+				reload.restart = append(reload.restart, settingName(setting.name))
+			} else {
+				reload.changed = append(reload.changed, settingName(setting.name))
+			}
+			break
+		}
+	}
+
+	sort.Strings(reload.changed)
+	sort.Strings(reload.restart)
+
+	return reload
+}
+
+// reloadConfig reads the configuration file again and, if it's valid,
+// swaps it for the configuration Mario runs with.
+// the chat connections stay open, the settings they were opened with need a restart
+// Returns what changed, or an error and Mario keeps the current configuration
+func reloadConfig(adapter string) (configReload, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	next, err := readConfig()
+
+	if err != nil {
+		return configReload{}, fmt.Errorf("%v, I keep the current configuration", err)
+	}
+
+	if errs := validateConfig(next, adapter); len(errs) > 0 {
+		var problems []string
+		for _, err := range errs {
+			problems = append(problems, err.Error())
+		}
+		return configReload{}, fmt.Errorf("%s, I keep the current configuration", strings.Join(problems, "; "))
+	}
+
+	previous := useConfig(next)
+	reload := diffConfig(previous, next)
+
+	for _, hook := range reloadHooks {
+		if err := hook(); err != nil {
+			return reload, fmt.Errorf("the configuration of %s was swapped but could not be applied: %v", next.path, err)
+		}
+	}
+
+	return reload, nil
+}
+
+// reportReload tells the admins of every workspace how a reload went,
+// in their ADMIN_CHANNEL
+func reportReload(chats []chatAgent, reload configReload, err error) {
+	report := reload.String()
+
+	if err != nil {
+		report = "Cannot reload the configuration: " + err.Error()
+		logs.error("cannot reload the configuration", "err", err)
+	} else {
+		logs.info("reloaded the configuration", "path", reload.path, "changed", strings.Join(reload.changed, ", "), "restart", strings.Join(reload.restart, ", "))
+	}
+
+	for _, chat := range chats {
+		channel := workspaceSetting(workspaceOf(chat), "ADMIN_CHANNEL")
+
+		if channel == "" {
+			continue
+		}
+
+		if err := chat.postMessage(Message{Type: "message", Channel: channel, Text: report}); err != nil {
+			logs.error("cannot report the reload", "channel", channel, "err", err)
+		}
+	}
+}
+
+// reloadAndReport reloads the configuration and reports how it went
+func reloadAndReport(chats []chatAgent, adapter string) (configReload, error) {
+	reload, err := reloadConfig(adapter)
+	reportReload(chats, reload, err)
+	return reload, err
+}
+
+// watchConfig reloads the configuration when Mario receives SIGHUP
+// and when the configuration file changes
+func watchConfig(chats []chatAdapter, adapter string) {
+	reload := func() error {
+		_, err := reloadAndReport(agents(chats), adapter)
+		return err
+	}
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	go func() {
+		for range hangup {
+			reload()
+		}
+	}()
+
+	// the content of the file a reload failed with, not to report it again
+	var failed [sha256.Size]byte
+
+	jobs.every("configuration watcher", configWatchInterval, func() error {
+		path := findConfig()
+
+		if path == "" {
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(path)
+
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(data)
+		current := config()
+
+		if current.path == path && current.sum == sum || failed == sum {
+			return nil
+		}
+
+		err = reload()
+
+		if err != nil {
+			failed = sum
+		}
+
+		return err
+	})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// test reloading the configuration file
+func TestReloadConfig(t *testing.T) {
+	path, restore := withConfigFile(t, testConfig)
+	defer restore()
+
+	if err := loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	applied := 0
+	previousHooks := reloadHooks
+	onReload(func() error {
+		applied++
+		return nil
+	})
+	defer func() { reloadHooks = previousHooks }()
+
+	changed := strings.Replace(testConfig, "staging: [\"*\"]", "staging: [U0ONE]", 1)
+	changed = strings.Replace(changed, "xoxb-two", "xoxb-three", 1)
+	changed += "admin_channel: C0ADMIN\n"
+
+	if err := ioutil.WriteFile(path, []byte(changed), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	reload, err := reloadConfig("slack")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "Reloaded the configuration from " + path + ", changed admin_channel (ADMIN_CHANNEL), wercker.deployers (WERCKER_DEPLOYERS). Restart me to apply slack.tokens (TOKEN)"
+	if reload.String() != expected {
+		t.Errorf("Expected the report %q, got %q", expected, reload.String())
+	}
+
+	if value := workspaceSetting("T1", "WERCKER_DEPLOYERS"); value != "production=U0ONE|U0TWO,staging=U0ONE" {
+		t.Errorf("Expected the new deployers to apply, got %q", value)
+	}
+
+	if applied != 1 {
+		t.Errorf("Expected the reload hooks to run once, got %d", applied)
+	}
+
+	// an invalid configuration is refused and the current one is kept
+	if err := ioutil.WriteFile(path, []byte(changed+"wercker:\n  watch_interval: soon\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := reloadConfig("slack"); err == nil || !strings.Contains(err.Error(), "I keep the current configuration") {
+		t.Errorf("Expected the invalid file to be refused, got %v", err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(strings.Replace(changed, "xoxb-one, xoxb-three", "", 1)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := reloadConfig("slack"); err == nil || !strings.Contains(err.Error(), "slack.tokens (TOKEN) is required") {
+		t.Errorf("Expected the missing tokens to be refused, got %v", err)
+	}
+
+	if value := workspaceSetting("", "ADMIN_CHANNEL"); value != "C0ADMIN" || applied != 1 {
+		t.Errorf("Expected the last valid configuration to be kept, got %q after %d reloads", value, applied)
+	}
+
+	reload, err = reloadConfig("slack")
+	if err == nil {
+		t.Errorf("Expected the file to still be invalid, got %q", reload)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(changed), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if reload, err := reloadConfig("slack"); err != nil || !strings.HasSuffix(reload.String(), "nothing changed") {
+		t.Errorf("Expected nothing to change, got %q, %v", reload, err)
+	}
+}
+
+// test the reloads are reported in the admin channel
+func TestReportReload(t *testing.T) {
+	_, restore := withConfigFile(t, testConfig+"admin_channel: C0ADMIN\n")
+	defer restore()
+
+	if err := loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	chat := &FakeSlackChat{}
+	reportReload([]chatAgent{chat}, configReload{path: "mario.yml", changed: []string{"admins (MARIO_ADMINS)"}}, nil)
+	reportReload([]chatAgent{chat}, configReload{}, fmt.Errorf("broken"))
+
+	replies := chat.replies()
+	expected := []string{
+		"Reloaded the configuration from mario.yml, changed admins (MARIO_ADMINS)",
+		"Cannot reload the configuration: broken",
+	}
+
+	if len(replies) != len(expected) {
+		t.Fatalf("Expected %d reports, got %+v", len(expected), replies)
+	}
+
+	for i, reply := range replies {
+		if reply.Channel != "C0ADMIN" || reply.Text != expected[i] {
+			t.Errorf("Expected %q in C0ADMIN, got %+v", expected[i], reply)
+		}
+	}
+}
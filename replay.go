@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/umbrellium/mario/wercker"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	recordPath = flag.String("record", "", "record every Slack frame to this JSONL file")
+	replayPath = flag.String("replay", "", "the JSONL recording the replay adapter feeds to Mario")
+)
+
+// recordedFrame is a line of a recording
+// Direction is "connect" when Mario connects to a workspace,
+// "in" for events received from Slack and "out" for frames sent by Mario
+type recordedFrame struct {
+	Time      time.Time       `json:"time"`
+	Direction string          `json:"direction"`
+	Workspace string          `json:"workspace,omitempty"`
+	Self      string          `json:"self,omitempty"`
+	Frame     json.RawMessage `json:"frame,omitempty"`
+}
+
+// recorder writes the frames exchanged with Slack to a JSONL file
+// a nil recorder records nothing
+type recorder struct {
+	mu      sync.Mutex
+	out     io.Writer
+	secrets []string
+}
+
+// newRecorder creates a recorder that writes to out
+// the secrets, and those redact knows about, are redacted from every recorded frame
+func newRecorder(out io.Writer, secrets ...string) *recorder {
+	r := &recorder{out: out}
+
+	for _, secret := range secrets {
+		if secret != "" {
+			r.secrets = append(r.secrets, secret)
+		}
+	}
+
+	return r
+}
+
+// record writes a frame received or sent by Mario
+func (r *recorder) record(direction, workspace string, frame []byte) {
+	if r == nil {
+		return
+	}
+
+	r.write(recordedFrame{Direction: direction, Workspace: workspace, Frame: r.redact(frame)})
+}
+
+// recordConnect writes who Mario is in a workspace
+func (r *recorder) recordConnect(workspace, self string) {
+	if r == nil {
+		return
+	}
+
+	r.write(recordedFrame{Direction: "connect", Workspace: workspace, Self: self})
+}
+
+func (r *recorder) write(frame recordedFrame) {
+	frame.Time = time.Now().UTC()
+	line, err := json.Marshal(frame)
+
+	if err != nil {
+		logs.error("cannot record frame", "err", err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, err = r.out.Write(append(line, '\n'))
+
+	if err != nil {
+		logs.error("cannot record frame", "err", err)
+	}
+}
+
+// redact removes tokens from a frame
+// Returns a copy of the frame
+func (r *recorder) redact(frame []byte) json.RawMessage {
+	text := string(frame)
+
+	for _, secret := range r.secrets {
+		text = strings.Replace(text, secret, redacted, -1)
+	}
+
+	text = redact(text)
+
+	// the frame must stay valid JSON to be recorded as is
+	if !json.Valid([]byte(text)) {
+		quoted, _ := json.Marshal(text)
+		return json.RawMessage(quoted)
+	}
+
+	return json.RawMessage(text)
+}
+
+// Replay is a chat adapter that feeds a recording back to Mario
+// and reports where his replies diverge from the recorded ones
+type Replay struct {
+	Path string
+	Out  io.Writer
+
+	events []*replayedEvent
+	bots   map[string]string
+	// the event being replayed and the replies Mario posted to it
+	current  *replayedEvent
+	posted   []Message
+	replayed int
+	diverged int
+}
+
+// replayedEvent is a recorded event and the replies Mario sent to it
+type replayedEvent struct {
+	msg      Message
+	expected []Message
+}
+
+// offlineTransport refuses every request,
+// a replay must not deploy or lock anything through the real APIs
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, fmt.Errorf("%s cannot be reached during a replay", req.URL.Host)
+}
+
+// newReplayAdapter creates a replay adapter reading the --replay recording,
+// the Wercker API is stubbed out for the replay
+// Returns the adapter and an error
+func newReplayAdapter() (chatAdapter, error) {
+	if *replayPath == "" {
+		return nil, fmt.Errorf("You must pass --replay=<recording> to use the replay adapter")
+	}
+
+	werckerClient = func(workspace string) *wercker.Client {
+		client := wercker.NewClient("")
+		client.HTTPClient = &http.Client{Transport: offlineTransport{}}
+		return client
+	}
+
+	return &Replay{Path: *replayPath, Out: os.Stdout}, nil
+}
+
+// Connect loads the recording
+// every reply is matched to the last event of its workspace and channel,
+// or of its workspace if Mario posted in a channel no event came from
+func (r *Replay) connect() error {
+	file, err := os.Open(r.Path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	r.bots = map[string]string{}
+	byChannel := map[string]*replayedEvent{}
+	byWorkspace := map[string]*replayedEvent{}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 10*1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		var frame recordedFrame
+
+		if err := json.Unmarshal(scanner.Bytes(), &frame); err != nil {
+			return fmt.Errorf("Error: %s line %d is not a recorded frame: %v", r.Path, line, err)
+		}
+
+		switch frame.Direction {
+		case "connect":
+			r.bots[frame.Workspace] = frame.Self
+		case "in":
+			msg, ok := parseSlackEvent(frame.Frame, frame.Workspace)
+
+			if !ok {
+				continue
+			}
+
+			event := &replayedEvent{msg: msg}
+			r.events = append(r.events, event)
+			byWorkspace[msg.Workspace] = event
+
+			if msg.Channel != "" {
+				byChannel[msg.Workspace+"/"+msg.Channel] = event
+			}
+		case "out":
+			var reply Message
+
+			if json.Unmarshal(frame.Frame, &reply) != nil || reply.Type != "message" {
+				continue
+			}
+
+			reply.Workspace = frame.Workspace
+			event := byChannel[reply.Workspace+"/"+reply.Channel]
+
+			if event == nil {
+				event = byWorkspace[reply.Workspace]
+			}
+
+			if event != nil {
+				event.expected = append(event.expected, reply)
+			}
+		}
+	}
+
+	return scanner.Err()
+}
+
+// GetMessage checks Mario's replies to the previous event
+// and replays the next recorded event
+// Returns the message, io.EOF at the end of the recording
+// or an error if Mario's replies diverged from the recording
+func (r *Replay) getMessage() (Message, error) {
+	r.compare()
+
+	if len(r.events) > 0 {
+		r.current = r.events[0]
+		r.events = r.events[1:]
+		r.replayed++
+
+		return r.current.msg, nil
+	}
+
+	fmt.Fprintf(r.Out, "Replayed %d events, %d diverged from the recording\n", r.replayed, r.diverged)
+
+	if r.diverged > 0 {
+		return Message{}, fmt.Errorf("Error: replay of %s diverged from the recording", r.Path)
+	}
+
+	return Message{}, io.EOF
+}
+
+// PostMessage keeps Mario's reply to compare it with the recording
+// the reply is redacted as Slack.postMessage redacts the recorded one
+func (r *Replay) postMessage(msg Message) error {
+	r.posted = append(r.posted, outgoingMessage(msg))
+	return nil
+}
+
+// messages addressed to Mario start with his recorded mention
+func (r *Replay) command(msg Message) (string, bool) {
+	mention := "<@" + r.bots[msg.Workspace] + ">"
+
+	if !strings.HasPrefix(msg.Text, mention) {
+		return "", false
+	}
+
+	return strings.TrimPrefix(msg.Text, mention), true
+}
+
+// byChannel groups messages by channel
+// Returns the groups and the channels in the order they first appear
+func byChannel(messages []Message, groups map[string][]Message, channels []string) ([]string, map[string][]Message) {
+	for _, msg := range messages {
+		if _, ok := groups[msg.Channel]; !ok {
+			channels = append(channels, msg.Channel)
+		}
+		groups[msg.Channel] = append(groups[msg.Channel], msg)
+	}
+
+	return channels, groups
+}
+
+// compare reports the differences between the replies Mario posted
+// and the recorded ones, channel by channel
+func (r *Replay) compare() {
+	if r.current == nil {
+		return
+	}
+
+	channels, expected := byChannel(r.current.expected, map[string][]Message{}, nil)
+	channels, posted := byChannel(r.posted, map[string][]Message{}, channels)
+	diverged := false
+
+	for _, channel := range channels {
+		for i := 0; i < len(expected[channel]) || i < len(posted[channel]); i++ {
+			var recordedReply, replayedReply string
+
+			if i < len(expected[channel]) {
+				recordedReply = fmt.Sprintf("%s: %q", channel, expected[channel][i].Text)
+			}
+
+			if i < len(posted[channel]) {
+				replayedReply = fmt.Sprintf("%s: %q", channel, posted[channel][i].Text)
+			}
+
+			if recordedReply != replayedReply {
+				diverged = true
+				fmt.Fprintf(r.Out, "Event %d, reply %d in %s:\n  recorded: %s\n  replayed: %s\n", r.replayed, i+1, channel, recordedReply, replayedReply)
+			}
+		}
+	}
+
+	if diverged {
+		r.diverged++
+	}
+
+	r.current = nil
+	r.posted = nil
+}
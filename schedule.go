@@ -0,0 +1,72 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// job is a function that Mario runs in the background at a regular interval
+type job struct {
+	Name      string
+	Interval  time.Duration
+	LastRun   time.Time
+	NextRun   time.Time
+	LastError string
+	run       func() error
+}
+
+// jobList holds the scheduled jobs
+type jobList struct {
+	mu    sync.Mutex
+	items []*job
+}
+
+// jobs that Mario runs in the background
+var jobs = &jobList{}
+
+// list returns a copy of the scheduled jobs, sorted by name
+func (j *jobList) list() []job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	list := make([]job, len(j.items))
+	for i, scheduled := range j.items {
+		list[i] = *scheduled
+		list[i].run = nil
+	}
+
+	sort.Slice(list, func(a, b int) bool { return list[a].Name < list[b].Name })
+
+	return list
+}
+
+// every runs fn in the background now and then at every interval
+func (j *jobList) every(name string, interval time.Duration, fn func() error) {
+	scheduled := &job{Name: name, Interval: interval, NextRun: time.Now(), run: fn}
+
+	j.mu.Lock()
+	j.items = append(j.items, scheduled)
+	j.mu.Unlock()
+
+	go func() {
+		for {
+			err := scheduled.run()
+
+			if err != nil {
+				logs.error("job failed", "job", name, "err", err)
+			}
+
+			j.mu.Lock()
+			scheduled.LastRun = time.Now()
+			scheduled.NextRun = scheduled.LastRun.Add(interval)
+			scheduled.LastError = ""
+			if err != nil {
+				scheduled.LastError = err.Error()
+			}
+			j.mu.Unlock()
+
+			time.Sleep(interval)
+		}
+	}()
+}
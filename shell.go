@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var (
+	shellUser    = flag.String("shell-user", "developer", "the user the shell adapter sends commands as")
+	shellChannel = flag.String("shell-channel", "shell", "the channel the shell adapter sends commands to")
+)
+
+// Shell is a chat adapter that reads commands from a terminal
+// so that Mario's tasks can be exercised locally without a Slack token
+type Shell struct {
+	In      io.Reader
+	Out     io.Writer
+	User    string
+	Channel string
+	scanner *bufio.Scanner
+}
+
+// newShellAdapter creates a shell adapter reading from stdin
+// Returns the adapter and an error
+func newShellAdapter() (chatAdapter, error) {
+	return &Shell{In: os.Stdin, Out: os.Stdout, User: *shellUser, Channel: *shellChannel}, nil
+}
+
+func (s *Shell) connect() error {
+	s.scanner = bufio.NewScanner(s.In)
+	_, err := fmt.Fprintf(s.Out, "Talking to Mario as %s in %s. Press ctrl+D to stop\n", s.User, s.Channel)
+	return err
+}
+
+// GetMessage reads the next non empty line typed in the terminal
+// Returns the message or io.EOF when there is nothing left to read
+func (s *Shell) getMessage() (Message, error) {
+	var msg Message
+
+	for {
+		fmt.Fprint(s.Out, "> ")
+
+		if !s.scanner.Scan() {
+			if err := s.scanner.Err(); err != nil {
+				return msg, err
+			}
+			return msg, io.EOF
+		}
+
+		text := strings.TrimSpace(s.scanner.Text())
+		if text != "" {
+			msg.Type = "message"
+			msg.Channel = s.Channel
+			msg.User = s.User
+			msg.Text = text
+			return msg, nil
+		}
+	}
+}
+
+// PostMessage prints Mario's reply to the terminal
+// Returns an error if it couldn't complete the operation
+func (s *Shell) postMessage(msg Message) error {
+	msg = outgoingMessage(msg)
+	_, err := fmt.Fprintf(s.Out, "mario: %s\n", msg.Text)
+	return err
+}
+
+// every line typed in the shell is a command for Mario
+// mentioning him is optional
+func (s *Shell) command(msg Message) (string, bool) {
+	options := strings.Fields(msg.Text)
+
+	if len(options) > 0 && (options[0] == "@mario" || options[0] == "mario:") {
+		return strings.TrimSpace(strings.TrimPrefix(msg.Text, options[0])), true
+	}
+
+	return msg.Text, true
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+// test that the shell adapter turns every line into a command
+func TestShellCommand(t *testing.T) {
+	shell := &Shell{}
+
+	type shellTestingStruct struct {
+		input    string
+		expected string
+	}
+
+	shellCommandTest := []shellTestingStruct{
+		{"hello", "hello"},
+		{"@mario hello", "hello"},
+		{"mario: help say", "help say"},
+		{"@marios hello", "@marios hello"},
+	}
+
+	for _, tst := range shellCommandTest {
+		res, ok := shell.command(Message{Text: tst.input})
+		if !ok || res != tst.expected {
+			t.Errorf("Expected %q to return %q, got %q instead", tst.input, tst.expected, res)
+		}
+	}
+}
+
+// test a local session from stdin to stdout
+func TestShellRun(t *testing.T) {
+	var out bytes.Buffer
+	shell := &Shell{In: strings.NewReader("hello\n\n@mario hello help\nbanana\n"), Out: &out, User: "tester", Channel: "shell"}
+
+	if err := shell.connect(); err != nil {
+		t.Fatalf("Expected connect to return no error, got %v", err)
+	}
+
+	err := run(shell)
+	if err != io.EOF {
+		t.Errorf("Expected run to stop with io.EOF, got %v", err)
+	}
+
+	for _, expected := range []string{"mario: Yo!", "mario: The <hello> command", "mario: I don't understand"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected shell output to contain %q, got %q", expected, out.String())
+		}
+	}
+}
@@ -5,8 +5,9 @@ import (
 	"fmt"
 	"github.com/umbrellium/mario/Godeps/_workspace/src/golang.org/x/net/websocket"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"strings"
+	"sync"
 	"sync/atomic"
 )
 
@@ -17,54 +18,143 @@ type chatAgent interface {
 
 type Slack struct {
 	Socket *websocket.Conn
+	Token  string
+	// Mario's Slack user ID, set when connecting
+	ID string
+	// the workspace Mario is connected to and its directory of users and channels
+	Team     slackTeam
+	Users    map[string]slackUser
+	Channels map[string]string
+	// Recorder writes every frame exchanged with Slack to a file, if set
+	Recorder *recorder
+
+	// mu guards the connection fields, they change when Mario reconnects
+	mu sync.RWMutex
 }
 
 type slackResponse struct {
-	Ok       bool   `json:"ok"`
-	Error    string `json:"error"`
-	Url      string `json:"url"`
-	Userdata userID `json:"self"`
+	Ok       bool           `json:"ok"`
+	Error    string         `json:"error"`
+	Url      string         `json:"url"`
+	Userdata userID         `json:"self"`
+	Team     slackTeam      `json:"team"`
+	Users    []slackUser    `json:"users"`
+	Channels []slackChannel `json:"channels"`
+	Groups   []slackChannel `json:"groups"`
 }
 
 type userID struct {
 	Id string `json:"id"`
 }
 
+type slackTeam struct {
+	Id     string `json:"id"`
+	Name   string `json:"name"`
+	Domain string `json:"domain"`
+}
+
+type slackUser struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	RealName string `json:"real_name"`
+	Profile  struct {
+		Email string `json:"email"`
+	} `json:"profile"`
+}
+
+type slackChannel struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
 // Message struct use to generate Slack messages
 type Message struct {
 	Id      uint64 `json:"id"`
 	Type    string `json:"type"`
 	Channel string `json:"channel"`
+	User    string `json:"user,omitempty"`
 	Text    string `json:"text"`
+	// Workspace is the ID of the Slack team the message belongs to
+	Workspace string `json:"team,omitempty"`
+	// Ts identifies the message and ThreadTs the thread it belongs to
+	Ts       string `json:"ts,omitempty"`
+	ThreadTs string `json:"thread_ts,omitempty"`
+	// CorrelationID identifies the incoming message in the logs,
+	// the replies Mario posts to it keep the ID
+	CorrelationID string `json:"-"`
+}
+
+// inThread returns a copy of the message that replies in its thread
+// if the message doesn't belong to a thread, the reply starts one
+func (msg Message) inThread() Message {
+	if msg.ThreadTs == "" {
+		msg.ThreadTs = msg.Ts
+	}
+	return msg
 }
 
 var counter uint64
 
+// slackAPI is the base URL of the Slack web API
+var slackAPI = "https://slack.com/api/"
+
+// newSlackAdapters creates one Slack adapter per workspace
+// the comma separated list of workspace tokens is the TOKEN setting
+// Returns the adapters and an error
+func newSlackAdapters() ([]chatAdapter, error) {
+	tokens := workspaceSetting("", "TOKEN")
+
+	if tokens == "" {
+		return nil, fmt.Errorf("You must set slack.tokens or TOKEN to connect to Slack")
+	}
+
+	var workspaces []chatAdapter
+
+	for _, token := range strings.Split(tokens, ",") {
+		if token = strings.TrimSpace(token); token != "" {
+			workspaces = append(workspaces, &Slack{Token: token})
+		}
+	}
+
+	return workspaces, nil
+}
+
 // ConnectToSlack starts Slack real time messaging and opens a websocket
-// Returns a websocket, a userID, an error
-func connectToSlack(token string) (*websocket.Conn, string, error) {
-	url := "https://slack.com/api/rtm.start?token=" + token
+// Returns a websocket, the rtm.start response, an error
+func connectToSlack(token string) (*websocket.Conn, slackResponse, error) {
+	var connectionResponse slackResponse
+
+	// the token is sent in a header, URLs end up in errors and logs
+	req, err := http.NewRequest("GET", slackAPI+"rtm.start", nil)
+
+	if err != nil {
+		return nil, connectionResponse, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
 
 	// connect to rtm
-	res, err := http.Get(url)
+	res, err := apiClient("slack").Do(req)
 
 	if err != nil {
-		log.Fatal(err)
+		return nil, connectionResponse, redactError(err)
 	}
 
+	defer res.Body.Close()
+
 	// store get response
 	body, err := ioutil.ReadAll(res.Body)
 
 	if err != nil {
-		log.Fatal(err)
+		return nil, connectionResponse, err
 	}
 
 	// assign get response to slackResponse struct
-	var connectionResponse slackResponse
 	json.Unmarshal(body, &connectionResponse)
 
 	if !connectionResponse.Ok {
-		log.Fatal(err)
+		err = fmt.Errorf("Error: cannot start slack real time messaging: %s", connectionResponse.Error)
+		return nil, connectionResponse, err
 	}
 
 	// connect to slack
@@ -72,32 +162,192 @@ func connectToSlack(token string) (*websocket.Conn, string, error) {
 
 	if err != nil {
 		err = fmt.Errorf("Error: cannot open slack websocket")
-		return socket, "", err
+		return socket, connectionResponse, err
+	}
+
+	return socket, connectionResponse, nil
+}
+
+// Connect opens the Slack websocket and stores Mario's user ID
+// and the directory of the workspace
+func (s *Slack) connect() error {
+	ws, connectionResponse, err := connectToSlack(s.Token)
+
+	if err != nil {
+		return err
+	}
+
+	users := map[string]slackUser{}
+	for _, user := range connectionResponse.Users {
+		users[user.Id] = user
+	}
+
+	channels := map[string]string{}
+	for _, channel := range append(connectionResponse.Channels, connectionResponse.Groups...) {
+		channels[channel.Id] = channel.Name
+	}
+
+	s.mu.Lock()
+	s.Socket = ws
+	s.ID = connectionResponse.Userdata.Id
+	s.Team = connectionResponse.Team
+	s.Users = users
+	s.Channels = channels
+	s.mu.Unlock()
+
+	// the replay adapter needs to know who Mario is in each workspace
+	s.Recorder.recordConnect(connectionResponse.Team.Id, connectionResponse.Userdata.Id)
+
+	return nil
+}
+
+// socket returns the current websocket, it changes when Mario reconnects
+func (s *Slack) socket() *websocket.Conn {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Socket
+}
+
+// botID returns Mario's user ID in the workspace
+func (s *Slack) botID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ID
+}
+
+// teamID returns the ID of the workspace
+func (s *Slack) teamID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Team.Id
+}
+
+// userName returns the name of a user of the workspace
+// or the user ID if the user is unknown
+func (s *Slack) userName(id string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if user, ok := s.Users[id]; ok {
+		return user.Name
+	}
+	return id
+}
+
+// findUser looks up a user of the workspace by name, real name or email
+// Returns the user ID and true if the user exists
+func (s *Slack) findUser(name string) (string, bool) {
+	if name == "" {
+		return "", false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for id, user := range s.Users {
+		email := strings.ToLower(user.Profile.Email)
+
+		if strings.EqualFold(user.Name, name) || strings.EqualFold(user.RealName, name) ||
+			email == strings.ToLower(name) || strings.HasPrefix(email, strings.ToLower(name)+"@") {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// channelID returns the ID of a channel of the workspace given its name
+// Returns the ID and true if the channel exists
+func (s *Slack) channelID(name string) (string, bool) {
+	name = strings.TrimPrefix(name, "#")
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for id, channelName := range s.Channels {
+		if channelName == name {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// messages addressed to Mario start with his mention, e.g. "<@U123> hello"
+func (s *Slack) command(msg Message) (string, bool) {
+	mention := "<@" + s.botID() + ">"
+
+	if !strings.HasPrefix(msg.Text, mention) {
+		return "", false
 	}
 
-	return socket, connectionResponse.Userdata.Id, nil
+	return strings.TrimPrefix(msg.Text, mention), true
 }
 
 // GetMessage listens to Slack messages
 // Returns the message or an error
 func (s *Slack) getMessage() (Message, error) {
+	socket, workspace := s.socket(), s.teamID()
+
+	for {
+		var raw []byte
+		err := websocket.Message.Receive(socket, &raw)
+
+		if err != nil {
+			logs.error("cannot get Slack message", "workspace", workspace, "err", err)
+			return Message{}, err
+		}
+
+		s.Recorder.record("in", workspace, raw)
 
-	// the message to return
+		// the message to return
+		msg, ok := parseSlackEvent(raw, workspace)
+
+		if ok {
+			return msg, nil
+		}
+	}
+}
+
+// parseSlackEvent decodes a raw RTM event
+// events that don't look like a message, e.g. with a channel object, are skipped
+// Returns the message and true if the event could be decoded
+func parseSlackEvent(raw []byte, workspace string) (Message, bool) {
 	var msg Message
-	err := websocket.JSON.Receive(s.Socket, &msg)
 
-	if err != nil {
-		fmt.Errorf("Error: cannot get message")
-		return msg, err
+	if json.Unmarshal(raw, &msg) != nil {
+		return msg, false
 	}
 
-	return msg, err
+	// not every event says which workspace it belongs to
+	if msg.Workspace == "" {
+		msg.Workspace = workspace
+	}
+
+	return msg, true
+}
+
+// ping asks Slack for a pong, the pongs show the connection is alive
+// pings aren't recorded, they aren't part of the conversation
+func (s *Slack) ping() error {
+	return websocket.JSON.Send(s.socket(), map[string]interface{}{"id": atomic.AddUint64(&counter, 1), "type": "ping"})
 }
 
 // PostMessage publishes a message on Slack
 // Returns an error if it couldn't complete the operation
 func (s *Slack) postMessage(msg Message) error {
+	msg = outgoingMessage(msg)
 	msg.Id = atomic.AddUint64(&counter, 1)
-	err := websocket.JSON.Send(s.Socket, msg)
-	return err
+	// the user, workspace and timestamp are only meaningful for incoming messages
+	msg.User = ""
+	msg.Workspace = ""
+	msg.Ts = ""
+
+	frame, err := json.Marshal(msg)
+
+	if err != nil {
+		return err
+	}
+
+	s.Recorder.record("out", s.teamID(), frame)
+
+	return websocket.Message.Send(s.socket(), string(frame))
 }
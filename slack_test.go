@@ -1,6 +1,12 @@
 package main
 
 import (
+	"encoding/json"
+	"github.com/umbrellium/mario/Godeps/_workspace/src/golang.org/x/net/websocket"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
 )
 
@@ -21,3 +27,152 @@ func TestPostMessage(t *testing.T) {
 }
 
 // test mario commands option
+
+// newFakeSlack starts a local stub of rtm.start and of the RTM websocket
+// every token is a workspace whose bot is called U<token>
+// the websocket sends a message mentioning the bot and records Mario's reply
+func newFakeSlack(t *testing.T) (*httptest.Server, <-chan Message) {
+	replies := make(chan Message, 8)
+	mux := http.NewServeMux()
+
+	var server *httptest.Server
+
+	mux.HandleFunc("/api/rtm.start", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RawQuery != "" {
+			t.Errorf("Expected the token to be sent in a header, got %s", r.URL)
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"ok":       true,
+			"url":      "ws" + strings.TrimPrefix(server.URL, "http") + "/rtm/" + token,
+			"self":     map[string]string{"id": "U" + token},
+			"team":     map[string]string{"id": "T" + token, "name": "team " + token},
+			"users":    []map[string]string{{"id": "Ualice", "name": "alice"}},
+			"channels": []map[string]string{{"id": "C" + token, "name": "general"}},
+		})
+	})
+
+	mux.Handle("/rtm/", websocket.Handler(func(ws *websocket.Conn) {
+		token := strings.TrimPrefix(ws.Request().URL.Path, "/rtm/")
+		websocket.JSON.Send(ws, Message{Type: "message", Channel: "C" + token, User: "Ualice", Text: "<@U" + token + "> hello"})
+
+		var reply Message
+		if websocket.JSON.Receive(ws, &reply) == nil {
+			replies <- reply
+		}
+	}))
+
+	server = httptest.NewServer(mux)
+	return server, replies
+}
+
+// test that Mario keeps a separate identity and directory per workspace
+func TestSlackWorkspaces(t *testing.T) {
+	server, replies := newFakeSlack(t)
+	defer server.Close()
+
+	defer func(api string) { slackAPI = api }(slackAPI)
+	slackAPI = server.URL + "/api/"
+
+	os.Setenv("TOKEN", "one, two")
+	defer os.Unsetenv("TOKEN")
+
+	chats, err := newSlackAdapters()
+	if err != nil || len(chats) != 2 {
+		t.Fatalf("Expected 2 workspaces, got %d and error %v", len(chats), err)
+	}
+
+	for _, chat := range chats {
+		slack := chat.(*Slack)
+
+		if err := slack.connect(); err != nil {
+			t.Fatalf("Expected connect to return no error, got %v", err)
+		}
+
+		if slack.ID != "U"+slack.Token || slack.Team.Id != "T"+slack.Token {
+			t.Errorf("Expected workspace %s to have its own bot and team, got %q %q", slack.Token, slack.ID, slack.Team.Id)
+		}
+
+		if id, ok := slack.channelID("#general"); !ok || id != "C"+slack.Token {
+			t.Errorf("Expected #general to be channel C%s, got %q", slack.Token, id)
+		}
+
+		if slack.userName("Ualice") != "alice" {
+			t.Errorf("Expected Ualice to be alice, got %q", slack.userName("Ualice"))
+		}
+
+		msg, err := slack.getMessage()
+		if err != nil {
+			t.Fatalf("Expected getMessage to return no error, got %v", err)
+		}
+
+		if msg.Workspace != "T"+slack.Token {
+			t.Errorf("Expected message to come from workspace T%s, got %q", slack.Token, msg.Workspace)
+		}
+
+		if err := dispatch(slack, msg); err != nil {
+			t.Fatalf("Expected dispatch to return no error, got %v", err)
+		}
+
+		reply := <-replies
+		if reply.Channel != "C"+slack.Token || reply.Text != "Yo!" || reply.Workspace != "" {
+			t.Errorf("Expected Mario to reply Yo! in C%s, got %+v", slack.Token, reply)
+		}
+	}
+}
+
+// test per workspace settings
+func TestWorkspaceSetting(t *testing.T) {
+	os.Setenv("MARIO_TEST_SETTING", "shared")
+	os.Setenv("MARIO_TEST_SETTING_T1", "team one")
+	defer os.Unsetenv("MARIO_TEST_SETTING")
+	defer os.Unsetenv("MARIO_TEST_SETTING_T1")
+
+	if res := workspaceSetting("T1", "MARIO_TEST_SETTING"); res != "team one" {
+		t.Errorf("Expected T1 to override the setting, got %q", res)
+	}
+
+	if res := workspaceSetting("T2", "MARIO_TEST_SETTING"); res != "shared" {
+		t.Errorf("Expected T2 to use the shared setting, got %q", res)
+	}
+}
+
+// test the directory can be read while Mario reconnects
+func TestSlackReconnectRace(t *testing.T) {
+	server, _ := newFakeSlack(t)
+	defer server.Close()
+
+	defer func(api string) { slackAPI = api }(slackAPI)
+	slackAPI = server.URL + "/api/"
+
+	slack := &Slack{Token: "one"}
+
+	if err := slack.connect(); err != nil {
+		t.Fatalf("Expected connect to return no error, got %v", err)
+	}
+
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 3; i++ {
+			slack.socket().Close()
+			if err := slack.connect(); err != nil {
+				t.Errorf("Expected connect to return no error, got %v", err)
+			}
+		}
+		close(done)
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+			slack.userName("Ualice")
+			slack.channelID("#general")
+			slack.findUser("alice")
+			slack.command(Message{Text: "<@Uone> hello"})
+			workspaceOf(slack)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"github.com/umbrellium/mario/brain"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// brainStore is what Mario remembers across restarts
+var brainStore brain.Store = brain.NewMemory()
+
+// openBrain opens the store set in BRAIN: "memory", "file:<path>"
+// or "redis://<host>:<port>/<db>", "rediss://" over TLS, by default the Redis database of REDIS_URL,
+// as set by the Heroku Redis add-on, or "file:mario-brain.json"
+func openBrain() error {
+	setting := workspaceSetting("", "BRAIN")
+	if setting == "" {
+		setting = workspaceSetting("", "REDIS_URL")
+	}
+	if setting == "" {
+		setting = "file:mario-brain.json"
+	}
+
+	// Heroku sets DYNO, the files of a dyno are lost when it restarts
+	if workspaceSetting("", "DYNO") != "" && !strings.HasPrefix(setting, "redis://") && !strings.HasPrefix(setting, "rediss://") {
+		logs.warn("the brain is lost when the dyno restarts, set BRAIN or REDIS_URL to a Redis URL", "brain", setting)
+	}
+
+	store, err := brain.Open(setting)
+
+	if err != nil {
+		return err
+	}
+
+	brainStore = store
+	return nil
+}
+
+// closeBrainOnSignal closes the brain when Mario is interrupted or terminated,
+// so that the file store writes its last changes before Mario exits
+func closeBrainOnSignal() {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-stop
+		logs.info("stopping", "signal", sig.String())
+
+		if err := brainStore.Close(); err != nil {
+			logs.error("cannot close the brain", "err", err)
+		}
+
+		os.Exit(0)
+	}()
+}
+
+// taskBrain returns the part of the brain where a task keeps its data,
+// tasks cannot read or overwrite each other's keys
+func taskBrain(task Task) brain.Store {
+	return brain.Namespace(brainStore, task.getName())
+}
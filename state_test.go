@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/umbrellium/mario/brain"
+	"testing"
+)
+
+// useBrain gives Mario an empty brain
+// Returns a function that restores the previous one
+func useBrain(t *testing.T) func() {
+	previous := brainStore
+	brainStore = brain.NewMemory()
+
+	return func() {
+		brainStore = previous
+	}
+}
+
+// test tasks cannot read each other's keys
+func TestTaskBrain(t *testing.T) {
+	defer useBrain(t)()
+
+	taskBrain(Hello{}).Set("greeting", []byte("Yo!"), 0)
+
+	if _, err := taskBrain(Say{}).Get("greeting"); err != brain.ErrNotFound {
+		t.Errorf("Expected the say task not to see the keys of the hello task, got %v", err)
+	}
+
+	if value, err := brainStore.Get("hello:greeting"); err != nil || string(value) != "Yo!" {
+		t.Errorf("Expected the key to be namespaced by the name of the task, got %q, %v", value, err)
+	}
+}
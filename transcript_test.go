@@ -0,0 +1,189 @@
+// conversation tests
+// every file in testdata/transcripts is a conversation with Mario, e.g.
+//
+//	# comments and blank lines are kept as they are
+//	> alice: @mario hello
+//	< mario: Yo!
+//	> alice: @mario help hello
+//	< mario: The <hello> command simply prints a hello message to Slack.
+//	| This command doesn't take any other options
+//
+// lines starting with ">" are sent to Mario, lines starting with "<" are his replies
+// and lines starting with "|" continue a multi line reply.
+// Run "go test -run TestTranscripts -update" to rewrite the replies
+// after changing what Mario says
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the replies in testdata/transcripts")
+
+// transcriptChat is a chat adapter that sends the lines of a transcript to Mario
+// and records what he says back
+type transcriptChat struct {
+	FakeSlackChat
+	lines  []string
+	output []string
+}
+
+// newTranscriptChat parses a transcript, replies are dropped
+// as they will be recorded again
+func newTranscriptChat(transcript string) *transcriptChat {
+	chat := &transcriptChat{}
+
+	for _, line := range strings.Split(strings.TrimRight(transcript, "\n"), "\n") {
+		if strings.HasPrefix(line, "<") || strings.HasPrefix(line, "|") {
+			continue
+		}
+		chat.lines = append(chat.lines, line)
+	}
+
+	return chat
+}
+
+func (c *transcriptChat) connect() error {
+	return nil
+}
+
+// GetMessage records Mario's replies to the previous message
+// and sends him the next one
+func (c *transcriptChat) getMessage() (Message, error) {
+	c.record()
+
+	for len(c.lines) > 0 {
+		line := c.lines[0]
+		c.lines = c.lines[1:]
+		c.output = append(c.output, line)
+
+		if !strings.HasPrefix(line, ">") {
+			continue
+		}
+
+		parts := strings.SplitN(strings.TrimPrefix(line, ">"), ":", 2)
+		if len(parts) != 2 {
+			return Message{}, fmt.Errorf("invalid transcript line %q", line)
+		}
+
+		return Message{
+			Type:    "message",
+			Channel: "C0TRANSCRIPT",
+			User:    strings.TrimSpace(parts[0]),
+			Text:    strings.TrimSpace(parts[1]),
+		}, nil
+	}
+
+	return Message{}, io.EOF
+}
+
+// messages addressed to Mario start with "@mario"
+func (c *transcriptChat) command(msg Message) (string, bool) {
+	if !strings.HasPrefix(msg.Text, "@mario ") {
+		return "", false
+	}
+	return strings.TrimPrefix(msg.Text, "@mario "), true
+}
+
+// record adds the replies posted since the last message to the transcript
+func (c *transcriptChat) record() {
+	for _, reply := range c.replies() {
+		lines := strings.Split(reply.Text, "\n")
+		c.output = append(c.output, strings.TrimRight("< mario: "+lines[0], " "))
+
+		for _, line := range lines[1:] {
+			c.output = append(c.output, strings.TrimRight("| "+line, " "))
+		}
+	}
+}
+
+func (c *transcriptChat) transcript() string {
+	return strings.Join(c.output, "\n") + "\n"
+}
+
+// test every conversation in testdata/transcripts
+func TestTranscripts(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "transcripts", "*.txt"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("Expected transcripts in testdata/transcripts, got %v", err)
+	}
+
+	for _, file := range files {
+		expected, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Cannot read %s: %v", file, err)
+		}
+
+		chat := newTranscriptChat(string(expected))
+
+		if err := run(chat); err != io.EOF {
+			t.Errorf("%s: expected the conversation to end with io.EOF, got %v", file, err)
+			continue
+		}
+
+		actual := chat.transcript()
+
+		if *update {
+			if err := ioutil.WriteFile(file, []byte(actual), 0644); err != nil {
+				t.Fatalf("Cannot update %s: %v", file, err)
+			}
+			continue
+		}
+
+		if actual != string(expected) {
+			t.Errorf("%s: Mario's replies differ from the transcript (run with -update to accept them):\n%s",
+				file, diffLines(string(expected), actual))
+		}
+	}
+}
+
+// diffLines returns a line by line diff of two texts,
+// lines only in a are prefixed with "-" and lines only in b with "+"
+func diffLines(a, b string) string {
+	x := strings.Split(a, "\n")
+	y := strings.Split(b, "\n")
+
+	// longest common subsequence lengths
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var diff []string
+	i, j := 0, 0
+
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			diff = append(diff, "  "+x[i])
+			i++
+			j++
+		case j < len(y) && (i == len(x) || lcs[i][j+1] >= lcs[i+1][j]):
+			diff = append(diff, "+ "+y[j])
+			j++
+		default:
+			diff = append(diff, "- "+x[i])
+			i++
+		}
+	}
+
+	return strings.Join(diff, "\n")
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"fmt"
+	"github.com/umbrellium/mario/brain"
+	"github.com/umbrellium/mario/wercker"
+	"sort"
+	"strings"
+	"time"
+)
+
+// werckerWatcher polls the builds and deploys of the watched apps
+// and announces them in the channel of each app
+type werckerWatcher struct {
+	chat      chatAgent
+	workspace string
+	// what Mario already announced, e.g. "umbrellium/mario/build/<id>" is "running".
+	// an app is in the store once its current builds and deploys are known
+	seen brain.Store
+}
+
+// how long Mario remembers the builds and deploys he announced,
+// they are too old to change afterwards
+var watchMemory = 30 * 24 * time.Hour
+
+// announced returns what Mario announced in a workspace,
+// the watcher and the webhook share it so that a build is announced once
+func announced(workspace string) brain.Store {
+	return brain.Namespace(brainStore, "watch/"+workspace)
+}
+
+// userDirectory is implemented by the adapters that can look up
+// the users of their workspace
+type userDirectory interface {
+	findUser(name string) (string, bool)
+}
+
+// appChannels reads a setting mapping Wercker apps to channels
+// Returns the channel ID of every app
+func appChannels(workspace, name string) (map[string]string, error) {
+	return parseAppChannels(name, workspaceSetting(workspace, name))
+}
+
+// parseAppChannels parses the value of a setting mapping Wercker apps to channels,
+// a comma separated list of "<organisation>/<app>=<channel ID>"
+// Returns the channel ID of every app
+func parseAppChannels(name, value string) (map[string]string, error) {
+	apps := map[string]string{}
+
+	for _, mapping := range strings.Split(value, ",") {
+		if strings.TrimSpace(mapping) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(mapping, "=", 2)
+
+		if len(parts) != 2 || !strings.Contains(parts[0], "/") || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("Error: %s entries must look like <organisation>/<app>=<channel ID>, got %q", settingName(name), mapping)
+		}
+
+		apps[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return apps, nil
+}
+
+// newWerckerWatcher creates a watcher for the apps in WERCKER_WATCH
+// Returns nil if no app is watched in the workspace
+func newWerckerWatcher(chat chatAgent, workspace string) (*werckerWatcher, error) {
+	apps, err := appChannels(workspace, "WERCKER_WATCH")
+
+	if err != nil || len(apps) == 0 {
+		return nil, err
+	}
+
+	return &werckerWatcher{chat: chat, workspace: workspace, seen: announced(workspace)}, nil
+}
+
+// startWerckerWatchers starts a watcher for every chat connection
+// that has apps to watch, and for those that get apps to watch
+// when the configuration is reloaded
+func startWerckerWatchers(chats []chatAdapter) error {
+	interval := time.Minute
+
+	if setting := workspaceSetting("", "WERCKER_WATCH_INTERVAL"); setting != "" {
+		var err error
+		interval, err = time.ParseDuration(setting)
+
+		if err != nil || interval < time.Second {
+			return fmt.Errorf("Error: WERCKER_WATCH_INTERVAL must be a duration such as 30s or 5m, got %q", setting)
+		}
+	}
+
+	watched := map[chatAdapter]bool{}
+
+	start := func() error {
+		for _, chat := range chats {
+			if watched[chat] {
+				continue
+			}
+
+			workspace := workspaceOf(chat)
+			watcher, err := newWerckerWatcher(chat, workspace)
+
+			if err != nil {
+				return err
+			}
+
+			if watcher != nil {
+				watched[chat] = true
+				jobs.every("wercker watcher "+workspace, interval, watcher.poll)
+			}
+		}
+
+		return nil
+	}
+
+	onReload(start)
+
+	return start()
+}
+
+// poll checks the builds and deploys of every watched app
+// and announces what changed since the last poll
+// the apps are read at every poll, so they follow the configuration
+func (w *werckerWatcher) poll() error {
+	client := werckerClient(w.workspace)
+	channels, err := appChannels(w.workspace, "WERCKER_WATCH")
+
+	if err != nil {
+		return err
+	}
+
+	var apps []string
+	for app := range channels {
+		apps = append(apps, app)
+	}
+	sort.Strings(apps)
+
+	var failed []string
+
+	for _, app := range apps {
+		if err := w.pollApp(client, app, channels[app]); err != nil {
+			failed = append(failed, app+": "+err.Error())
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("cannot poll %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// pollApp announces the new builds and deploys of an app in its channel
+// and those that finished, then remembers them
+func (w *werckerWatcher) pollApp(client *wercker.Client, app, channel string) error {
+	parts := strings.SplitN(app, "/", 2)
+	org, name := parts[0], parts[1]
+
+	buildOpts := wercker.BuildOptions{}
+	buildOpts.Limit = 10
+	builds, err := client.Builds(org, name, buildOpts)
+
+	if err != nil {
+		return err
+	}
+
+	deployOpts := wercker.DeployOptions{}
+	deployOpts.Limit = 10
+	deploys, err := client.Deploys(org, name, deployOpts)
+
+	if err != nil {
+		return err
+	}
+
+	// the first time an app is polled, its builds are only remembered,
+	// otherwise Mario would announce its whole history
+	_, err = w.seen.Get(app)
+	known := err == nil
+
+	if err != nil && err != brain.ErrNotFound {
+		return err
+	}
+
+	var notices []string
+
+	// the API lists the most recent first, announce them in order
+	for i := len(builds) - 1; i >= 0; i-- {
+		build := builds[i]
+		changed, err := changedState(w.seen, app+"/build/"+build.ID, build.Status, build.Result)
+
+		if err != nil {
+			return err
+		}
+
+		if known && changed {
+			notices = append(notices, formatBuildNotice(app, build, mentionAuthor(w.chat, w.workspace, build.User, build.Result)))
+		}
+	}
+
+	for i := len(deploys) - 1; i >= 0; i-- {
+		deploy := deploys[i]
+		changed, err := changedState(w.seen, app+"/deploy/"+deploy.ID, deploy.Status, deploy.Result)
+
+		if err != nil {
+			return err
+		}
+
+		if known && changed {
+			notices = append(notices, formatDeployNotice(app, deploy))
+		}
+	}
+
+	if err := w.seen.Set(app, []byte("watched"), 0); err != nil {
+		return err
+	}
+
+	for _, notice := range notices {
+		err := w.chat.postMessage(Message{Type: "message", Channel: channel, Text: notice})
+
+		if err != nil {
+			logs.error("cannot announce", "workspace", w.workspace, "app", app, "err", err)
+		}
+	}
+
+	return nil
+}
+
+// changedState remembers the state of a build or deploy
+// and tells whether it must be announced: when it appears and when it finishes.
+// Only one of the callers that see the same change is told to announce it
+func changedState(seen brain.Store, key, status, result string) (bool, error) {
+	state := []byte(buildState(status, result))
+
+	for {
+		previous, err := seen.Get(key)
+
+		if err == brain.ErrNotFound {
+			previous = nil
+		} else if err != nil {
+			return false, err
+		}
+
+		if previous != nil && string(previous) == string(state) {
+			return false, nil
+		}
+
+		swapped, err := seen.CompareAndSwap(key, previous, state, watchMemory)
+
+		if err != nil {
+			return false, err
+		}
+
+		if swapped {
+			return previous == nil || status == "finished", nil
+		}
+	}
+}
+
+// mentionAuthor returns how to mention the author of a failed build in chat,
+// if the author can be found in the workspace.
+// WERCKER_USERS maps Wercker usernames to user IDs,
+// e.g. "alice=U024BE7LH,bob=U024BE7LJ", otherwise the directory of the workspace is searched
+func mentionAuthor(chat chatAgent, workspace string, user *wercker.User, result string) string {
+	username := user.Username()
+
+	if result != "failed" || username == "" {
+		return ""
+	}
+
+	for _, mapping := range strings.Split(workspaceSetting(workspace, "WERCKER_USERS"), ",") {
+		parts := strings.SplitN(mapping, "=", 2)
+
+		if len(parts) == 2 && strings.TrimSpace(parts[0]) == username {
+			return "<@" + strings.TrimSpace(parts[1]) + ">"
+		}
+	}
+
+	if directory, ok := chat.(userDirectory); ok {
+		for _, name := range []string{username, user.Name} {
+			if id, found := directory.findUser(name); found {
+				return "<@" + id + ">"
+			}
+		}
+	}
+
+	return ""
+}
+
+// formatBuildNotice announces a build of an app on a single line
+// mention is added to the notice if it isn't empty
+func formatBuildNotice(app string, build wercker.Build, mention string) string {
+	author := build.User.Username()
+	if author == "" {
+		author = "unknown"
+	}
+
+	notice := fmt.Sprintf("%s: build `%s` of %s at `%s` by %s ", app, build.ID, build.Branch, shortCommit(build.CommitHash), author)
+
+	if build.Status == "finished" {
+		notice += fmt.Sprintf("*%s*, %s", build.Result, buildDuration(build.StartedAt, build.FinishedAt))
+	} else {
+		notice += "started"
+	}
+
+	if mention != "" {
+		notice += " " + mention
+	}
+
+	return notice
+}
+
+// formatDeployNotice announces a deploy of an app on a single line
+func formatDeployNotice(app string, deploy wercker.Deploy) string {
+	notice := fmt.Sprintf("%s: deploy `%s`", app, deploy.ID)
+
+	if deploy.Build != nil {
+		notice += fmt.Sprintf(" of %s at `%s`", deploy.Build.Branch, shortCommit(deploy.Build.CommitHash))
+	}
+
+	if username := deploy.User.Username(); username != "" {
+		notice += " by " + username
+	}
+
+	if deploy.Status == "finished" {
+		return notice + fmt.Sprintf(" *%s*, %s", deploy.Result, buildDuration(deploy.StartedAt, deploy.FinishedAt))
+	}
+
+	return notice + " started"
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// directoryChat is a recording chat agent that knows alice
+type directoryChat struct {
+	FakeSlackChat
+}
+
+func (c *directoryChat) findUser(name string) (string, bool) {
+	return "Ualice", name == "alice"
+}
+
+// test announcing the builds and deploys of a watched app
+func TestWerckerWatcher(t *testing.T) {
+	var mu sync.Mutex
+	builds := `[{"id":"b1","branch":"master","commitHash":"0123456789ab","status":"finished","result":"passed"}]`
+	deploys := `[]`
+
+	defer useFakeWercker(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.URL.Path {
+		case "/applications/umbrellium/mario/builds":
+			w.Write([]byte(builds))
+		case "/applications/umbrellium/mario/deploys":
+			w.Write([]byte(deploys))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})()
+
+	set := func(newBuilds, newDeploys string) {
+		mu.Lock()
+		builds, deploys = newBuilds, newDeploys
+		mu.Unlock()
+	}
+
+	defer useBrain(t)()
+
+	// b1 was announced before Mario restarted
+	brainStore.Set("watch/:umbrellium/mario", []byte("watched"), 0)
+	brainStore.Set("watch/:umbrellium/mario/build/b1", []byte("passed"), 0)
+
+	os.Setenv("WERCKER_WATCH", "umbrellium/mario=C0BUILDS")
+	defer os.Unsetenv("WERCKER_WATCH")
+
+	chat := &directoryChat{}
+	watcher, err := newWerckerWatcher(chat, "")
+	if err != nil || watcher == nil {
+		t.Fatalf("Expected a watcher, got %v", err)
+	}
+
+	poll := func(w *werckerWatcher, expected ...string) {
+		if err := w.poll(); err != nil {
+			t.Fatalf("Expected poll to return no error, got %v", err)
+		}
+
+		replies := chat.replies()
+		if len(replies) != len(expected) {
+			t.Fatalf("Expected %d notices, got %+v", len(expected), replies)
+		}
+
+		for i, reply := range replies {
+			if reply.Channel != "C0BUILDS" || reply.Text != expected[i] {
+				t.Errorf("Expected %q in C0BUILDS, got %q in %s", expected[i], reply.Text, reply.Channel)
+			}
+		}
+	}
+
+	poll(watcher)
+
+	set(`[{"id":"b2","branch":"master","commitHash":"89abcdef0123","status":"running","startedAt":"2016-01-01T10:00:00Z","user":{"meta":{"username":"alice"}}},
+		{"id":"b1","branch":"master","commitHash":"0123456789ab","status":"finished","result":"passed"}]`, `[]`)
+	poll(watcher, "umbrellium/mario: build `b2` of master at `89abcde` by alice started")
+	poll(watcher)
+
+	set(`[{"id":"b2","branch":"master","commitHash":"89abcdef0123","status":"finished","result":"failed","startedAt":"2016-01-01T10:00:00Z","finishedAt":"2016-01-01T10:01:00Z","user":{"meta":{"username":"alice"}}},
+		{"id":"b1","branch":"master","commitHash":"0123456789ab","status":"finished","result":"passed"}]`,
+		`[{"id":"d1","status":"running","build":{"id":"b1","branch":"master","commitHash":"0123456789ab"}}]`)
+	poll(watcher,
+		"umbrellium/mario: build `b2` of master at `89abcde` by alice *failed*, took 1m0s <@Ualice>",
+		"umbrellium/mario: deploy `d1` of master at `0123456` started")
+
+	// nothing is announced twice after a restart
+	watcher, err = newWerckerWatcher(chat, "")
+	if err != nil {
+		t.Fatalf("Expected a watcher, got %v", err)
+	}
+	poll(watcher)
+
+	set(builds, `[{"id":"d1","status":"finished","result":"passed","startedAt":"2016-01-01T10:00:00Z","finishedAt":"2016-01-01T10:00:30Z","build":{"id":"b1","branch":"master","commitHash":"0123456789ab"}}]`)
+	poll(watcher, "umbrellium/mario: deploy `d1` of master at `0123456` *passed*, took 30s")
+}
+
+// test Wercker errors are reported without announcing anything
+func TestWerckerWatcherErrors(t *testing.T) {
+	defer useBrain(t)()
+	defer useFakeWercker(t, fakeWerckerBuilds)()
+
+	os.Setenv("WERCKER_WATCH", "umbrellium/mario=C0BUILDS")
+	defer os.Unsetenv("WERCKER_WATCH")
+
+	chat := &FakeSlackChat{}
+	watcher, err := newWerckerWatcher(chat, "")
+	if err != nil {
+		t.Fatalf("Expected a watcher, got %v", err)
+	}
+
+	if err := watcher.poll(); err == nil || !strings.Contains(err.Error(), "umbrellium/mario") {
+		t.Errorf("Expected the missing deploys to be reported, got %v", err)
+	}
+
+	if replies := chat.replies(); len(replies) != 0 {
+		t.Errorf("Expected the first poll to be silent, got %+v", replies)
+	}
+}
+
+// test the format of WERCKER_WATCH
+func TestWerckerWatcherConfig(t *testing.T) {
+	os.Setenv("WERCKER_WATCH", "mario=C0BUILDS")
+	defer os.Unsetenv("WERCKER_WATCH")
+
+	_, err := newWerckerWatcher(&FakeSlackChat{}, "")
+	if err == nil || !strings.Contains(err.Error(), "<organisation>/<app>=<channel ID>") {
+		t.Errorf("Expected an invalid WERCKER_WATCH to be reported, got %v", err)
+	}
+
+	os.Unsetenv("WERCKER_WATCH")
+
+	watcher, err := newWerckerWatcher(&FakeSlackChat{}, "")
+	if watcher != nil || err != nil {
+		t.Errorf("Expected no watcher without apps to watch, got %v, %v", watcher, err)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"github.com/umbrellium/mario/wercker"
+	"net/http"
+)
+
+// werckerWebhook receives the build and deploy events Wercker pushes
+// and announces them in the channel of each app
+type werckerWebhook struct {
+	secret string
+	chats  []chatAgent
+}
+
+// the largest webhook payload Mario reads
+const maxWebhookSize = 1 << 20
+
+// newWerckerWebhook creates the webhook receiver of the chat connections
+// the apps are mapped to channels in WERCKER_WEBHOOK_CHANNELS,
+// a comma separated list of "<organisation>/<app>=<channel ID>"
+// Returns nil if WERCKER_WEBHOOK_SECRET isn't set, Mario doesn't accept unauthenticated webhooks
+func newWerckerWebhook(chats []chatAgent) (*werckerWebhook, error) {
+	secret := workspaceSetting("", "WERCKER_WEBHOOK_SECRET")
+
+	if secret == "" {
+		return nil, nil
+	}
+
+	for _, chat := range chats {
+		if _, err := appChannels(workspaceOf(chat), "WERCKER_WEBHOOK_CHANNELS"); err != nil {
+			return nil, err
+		}
+	}
+
+	return &werckerWebhook{secret: secret, chats: chats}, nil
+}
+
+// handleWerckerWebhooks receives the Wercker webhooks on /wercker/webhook
+// Returns true if the webhook is enabled
+func handleWerckerWebhooks(chats []chatAdapter) (bool, error) {
+	webhook, err := newWerckerWebhook(agents(chats))
+
+	if err != nil || webhook == nil {
+		return false, err
+	}
+
+	httpMux.Handle("/wercker/webhook", webhook)
+
+	return true, nil
+}
+
+// ServeHTTP announces a webhook event
+// the secret is passed in the X-Webhook-Secret header
+func (h *werckerWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Only POST is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !h.authorized(r) {
+		http.Error(w, "Invalid secret", http.StatusUnauthorized)
+		return
+	}
+
+	event, err := wercker.ParseEvent(http.MaxBytesReader(w, r.Body, maxWebhookSize))
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if h.notify(event) == 0 {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "No channel is mapped to %s\n", werckerAppPath(event.Application))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorized checks the secret of a request,
+// it's only read from a header so that it doesn't end up in the access logs
+func (h *werckerWebhook) authorized(r *http.Request) bool {
+	secret := r.Header.Get("X-Webhook-Secret")
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(h.secret)) == 1
+}
+
+// notify posts an event in the channels mapped to its app,
+// unless the watcher or an earlier webhook already announced it
+// Returns the number of channels the app is mapped to
+func (h *werckerWebhook) notify(event wercker.Event) int {
+	app := werckerAppPath(event.Application)
+	mapped := 0
+
+	for _, chat := range h.chats {
+		workspace := workspaceOf(chat)
+		apps, _ := appChannels(workspace, "WERCKER_WEBHOOK_CHANNELS")
+		channel, ok := apps[app]
+
+		if !ok {
+			continue
+		}
+
+		mapped++
+
+		var key, notice string
+		var changed bool
+		var err error
+
+		// the app is described as in `list apps`
+		if event.Type == "build" {
+			key = app + "/build/" + event.Build.ID
+			changed, err = changedState(announced(workspace), key, event.Build.Status, event.Build.Result)
+			notice = formatBuildNotice(formatApp(event.Application), *event.Build, mentionAuthor(chat, workspace, event.Build.User, event.Build.Result))
+		} else {
+			key = app + "/deploy/" + event.Deploy.ID
+			changed, err = changedState(announced(workspace), key, event.Deploy.Status, event.Deploy.Result)
+			notice = formatDeployNotice(formatApp(event.Application), *event.Deploy)
+		}
+
+		if err != nil {
+			logs.error("cannot read what was announced", "workspace", workspace, "app", app, "err", err)
+		}
+
+		if !changed {
+			continue
+		}
+
+		if err := chat.postMessage(Message{Type: "message", Channel: channel, Text: notice}); err != nil {
+			logs.error("cannot announce", "workspace", workspace, "app", app, "err", err)
+		}
+	}
+
+	return mapped
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"github.com/umbrellium/mario/wercker"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// test receiving Wercker webhooks
+func TestWerckerWebhook(t *testing.T) {
+	os.Setenv("WERCKER_WEBHOOK_SECRET", "s3cret")
+	os.Setenv("WERCKER_WEBHOOK_CHANNELS", "umbrellium/mario=C0BUILDS")
+	os.Setenv("WERCKER_USERS", "alice=Ualice")
+	defer os.Unsetenv("WERCKER_WEBHOOK_SECRET")
+	defer os.Unsetenv("WERCKER_WEBHOOK_CHANNELS")
+	defer os.Unsetenv("WERCKER_USERS")
+
+	defer useBrain(t)()
+
+	chat := &FakeSlackChat{}
+	webhook, err := newWerckerWebhook([]chatAgent{chat})
+	if err != nil || webhook == nil {
+		t.Fatalf("Expected a webhook receiver, got %v", err)
+	}
+
+	app := `"application":{"name":"mario","owner":{"name":"umbrellium"},"privacy":"public"}`
+	failed := `{"type":"build",` + app + `,"build":{"id":"b2","branch":"master","commitHash":"89abcdef0123","status":"finished","result":"failed",
+		"startedAt":"2016-01-01T10:00:00Z","finishedAt":"2016-01-01T10:01:00Z","user":{"meta":{"username":"alice"}}}}`
+
+	var tests = []struct {
+		method  string
+		target  string
+		secret  string
+		payload string
+		status  int
+		notice  string
+	}{
+		{"GET", "/wercker/webhook", "s3cret", "", http.StatusMethodNotAllowed, ""},
+		{"POST", "/wercker/webhook", "", failed, http.StatusUnauthorized, ""},
+		{"POST", "/wercker/webhook", "wrong", failed, http.StatusUnauthorized, ""},
+		{"POST", "/wercker/webhook", "s3cret", `{"type":"build"}`, http.StatusBadRequest, ""},
+		{"POST", "/wercker/webhook", "s3cret", failed, http.StatusNoContent,
+			"mario (umbrellium, public) https://app.wercker.com/umbrellium/mario: build `b2` of master at `89abcde` by alice *failed*, took 1m0s <@Ualice>"},
+		{"POST", "/wercker/webhook", "s3cret", failed, http.StatusNoContent, ""},
+		{"POST", "/wercker/webhook?secret=s3cret", "", `{"type":"deploy",` + app + `,"deploy":{"id":"d1","status":"running"}}`, http.StatusUnauthorized, ""},
+		{"POST", "/wercker/webhook", "s3cret", `{"type":"deploy",` + app + `,"deploy":{"id":"d1","status":"running"}}`, http.StatusNoContent,
+			"mario (umbrellium, public) https://app.wercker.com/umbrellium/mario: deploy `d1` started"},
+		{"POST", "/wercker/webhook", "s3cret", `{"type":"deploy","application":{"name":"luigi","owner":{"name":"umbrellium"}},"deploy":{"id":"d2"}}`, http.StatusAccepted, ""},
+	}
+
+	for _, tst := range tests {
+		req := httptest.NewRequest(tst.method, tst.target, strings.NewReader(tst.payload))
+		if tst.secret != "" {
+			req.Header.Set("X-Webhook-Secret", tst.secret)
+		}
+
+		res := httptest.NewRecorder()
+		webhook.ServeHTTP(res, req)
+
+		if res.Code != tst.status {
+			t.Errorf("Expected %s %s to return %d, got %d: %s", tst.method, tst.target, tst.status, res.Code, res.Body)
+		}
+
+		replies := chat.replies()
+
+		if tst.notice == "" && len(replies) != 0 {
+			t.Errorf("Expected %s %s not to post anything, got %+v", tst.method, tst.target, replies)
+		}
+
+		if tst.notice != "" && (len(replies) != 1 || replies[0].Channel != "C0BUILDS" || replies[0].Text != tst.notice) {
+			t.Errorf("Expected %q in C0BUILDS, got %+v", tst.notice, replies)
+		}
+	}
+}
+
+// test the webhook is disabled without a secret
+func TestWerckerWebhookConfig(t *testing.T) {
+	webhook, err := newWerckerWebhook([]chatAgent{&FakeSlackChat{}})
+	if webhook != nil || err != nil {
+		t.Errorf("Expected no webhook without a secret, got %v, %v", webhook, err)
+	}
+
+	os.Setenv("WERCKER_WEBHOOK_SECRET", "s3cret")
+	os.Setenv("WERCKER_WEBHOOK_CHANNELS", "mario=C0BUILDS")
+	defer os.Unsetenv("WERCKER_WEBHOOK_SECRET")
+	defer os.Unsetenv("WERCKER_WEBHOOK_CHANNELS")
+
+	_, err = newWerckerWebhook([]chatAgent{&FakeSlackChat{}})
+	if err == nil || !strings.Contains(err.Error(), "WERCKER_WEBHOOK_CHANNELS") {
+		t.Errorf("Expected an invalid WERCKER_WEBHOOK_CHANNELS to be reported, got %v", err)
+	}
+}
+
+// test a build announced by the watcher isn't announced again by the webhook
+func TestWerckerWebhookAnnouncedOnce(t *testing.T) {
+	defer useBrain(t)()
+
+	os.Setenv("WERCKER_WEBHOOK_SECRET", "s3cret")
+	os.Setenv("WERCKER_WEBHOOK_CHANNELS", "umbrellium/mario=C0BUILDS")
+	defer os.Unsetenv("WERCKER_WEBHOOK_SECRET")
+	defer os.Unsetenv("WERCKER_WEBHOOK_CHANNELS")
+
+	chat := &FakeSlackChat{}
+	webhook, err := newWerckerWebhook([]chatAgent{chat})
+	if err != nil || webhook == nil {
+		t.Fatalf("Expected a webhook receiver, got %v", err)
+	}
+
+	// the watcher saw b1 start, then finish
+	for _, status := range []string{"running", "finished"} {
+		if changed, err := changedState(announced(""), "umbrellium/mario/build/b1", status, "passed"); !changed || err != nil {
+			t.Fatalf("Expected the watcher to announce b1 %s, got %t, %v", status, changed, err)
+		}
+	}
+
+	event := wercker.Event{
+		Type:        "build",
+		Application: wercker.Application{Name: "mario", Owner: wercker.Owner{Name: "umbrellium"}},
+		Build:       &wercker.Build{ID: "b1", Status: "finished", Result: "passed"},
+	}
+
+	if mapped := webhook.notify(event); mapped != 1 || len(chat.replies()) != 0 {
+		t.Errorf("Expected the webhook not to announce b1 again")
+	}
+}
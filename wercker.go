@@ -0,0 +1,405 @@
+// Wercker related tasks
+// they talk to the Wercker API with the client in the wercker package
+
+package main
+
+import (
+	"fmt"
+	"github.com/umbrellium/mario/wercker"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Wercker struct
+// performs Wercker related tasks (e.g. list apps, deploy app etc)
+type Wercker struct {
+}
+
+// werckerClient creates a Wercker API client for a workspace
+// the token is read from WERCKER_TOKEN
+// and the API URL can be changed with WERCKER_URL
+var werckerClient = func(workspace string) *wercker.Client {
+	// NOTE: token can be an empty string
+	// Wercker will return only public apps
+	client := wercker.NewClient(workspaceSetting(workspace, "WERCKER_TOKEN"))
+	client.HTTPClient = apiClient("wercker")
+
+	if url := workspaceSetting("", "WERCKER_URL"); url != "" {
+		client.BaseURL = url
+	}
+
+	return client
+}
+
+// werckerOrg returns the Wercker organisation of a channel
+// channels can have their own organisation in WERCKER_CHANNEL_ORGS,
+// e.g. "C024BE91L=umbrellium,C024BE91M=client", the others use WERCKER_ORG
+func werckerOrg(message Message) string {
+	for _, mapping := range strings.Split(workspaceSetting(message.Workspace, "WERCKER_CHANNEL_ORGS"), ",") {
+		parts := strings.SplitN(mapping, "=", 2)
+
+		if len(parts) == 2 && strings.TrimSpace(parts[0]) == message.Channel {
+			return strings.TrimSpace(parts[1])
+		}
+	}
+
+	if org := workspaceSetting(message.Workspace, "WERCKER_ORG"); org != "" {
+		return org
+	}
+
+	return "umbrellium"
+}
+
+// werckerAppLink returns the link to an application on the Wercker website
+func werckerAppLink(app wercker.Application) string {
+	return "https://app.wercker.com/" + werckerAppPath(app)
+}
+
+// werckerAppPath returns "<organisation>/<app>", the name Mario gives to apps
+func werckerAppPath(app wercker.Application) string {
+	return app.Owner.Username() + "/" + app.Name
+}
+
+// formatApp describes an application on a single line
+func formatApp(app wercker.Application) string {
+	return fmt.Sprintf("%s (%s, %s) %s", app.Name, app.Owner.Name, app.Privacy, werckerAppLink(app))
+}
+
+func (s Wercker) Hear(slack chatAgent, message Message, input string) bool {
+	patter, err := regexp.Compile(`^\blist apps\b`)
+
+	if err != nil {
+		taskLog(s, message).error("cannot parse the input", "err", err)
+	}
+
+	if patter.MatchString(input) {
+		options := strings.Fields(input)
+
+		if len(options) == 2 || (len(options) == 3 && options[2] != "help") {
+			org := werckerOrg(message)
+			if len(options) == 3 {
+				org = options[2]
+			}
+
+			err := Wercker.listApps(s, slack, message, org)
+			if err != nil {
+				taskLog(s, message).error("cannot list the apps", "err", err)
+			}
+			return true
+		}
+
+		if len(options) == 3 && options[2] == "help" {
+			// call help
+			err := Wercker.Help(s, slack, message)
+			if err != nil {
+				taskLog(s, message).error("cannot post the help", "err", err)
+				return false
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Wercker listApps
+// prints a list of the apps of an organisation that are currently available on Wercker
+func (s Wercker) listApps(slack chatAgent, message Message, org string) error {
+	availableApps, err := werckerClient(message.Workspace).AllApplications(org)
+
+	if err != nil {
+		message.Text = "Sorry, I couldn't get the list of " + org + " apps from Wercker: " + err.Error()
+		slack.postMessage(message)
+		return err
+	}
+
+	if len(availableApps) == 0 {
+		message.Text = "There are no " + org + " apps available on Wercker."
+		return slack.postMessage(message)
+	}
+
+	message.Text = "The following " + org + " apps are currently available on Wercker: \n"
+
+	// print response to slack
+	for _, app := range availableApps {
+		message.Text += formatApp(app) + fmt.Sprintf("\n")
+	}
+
+	err = slack.postMessage(message)
+	if err != nil {
+		taskLog(s, message).error("cannot post message", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s Wercker) Help(slack chatAgent, message Message) error {
+	message.Text = `<list apps> will list the applications currently available on Wercker.
+Usage:
+- @mario list apps
+- @mario list apps <organisation>
+
+Without an organisation, I list the apps of the organisation of this channel.
+`
+
+	err := slack.postMessage(message)
+
+	if err != nil {
+		taskLog(s, message).error("cannot post the help", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s Wercker) getName() string {
+	return "list apps"
+}
+
+// WerckerBuilds struct
+// lists the recent builds of an app and shows the details of a build
+type WerckerBuilds struct {
+}
+
+// werckerApp splits an "organisation/app" argument,
+// apps without an organisation belong to the organisation of the channel
+// Returns the organisation and the app name
+func werckerApp(message Message, arg string) (string, string) {
+	if parts := strings.SplitN(arg, "/", 2); len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return werckerOrg(message), arg
+}
+
+// parseFlags separates the "--name value" or "--name=value" flags of a command
+// from its arguments
+// Returns the arguments, the flags and an error if a flag has no value
+func parseFlags(options []string) ([]string, map[string]string, error) {
+	var args []string
+	flags := map[string]string{}
+
+	for i := 0; i < len(options); i++ {
+		if !strings.HasPrefix(options[i], "--") {
+			args = append(args, options[i])
+			continue
+		}
+
+		name := strings.TrimPrefix(options[i], "--")
+
+		if parts := strings.SplitN(name, "=", 2); len(parts) == 2 {
+			flags[parts[0]] = parts[1]
+			continue
+		}
+
+		if i+1 == len(options) {
+			return nil, nil, fmt.Errorf("--%s needs a value", name)
+		}
+
+		flags[name] = options[i+1]
+		i++
+	}
+
+	return args, flags, nil
+}
+
+// shortCommit returns the abbreviated hash of a commit
+func shortCommit(hash string) string {
+	if len(hash) > 7 {
+		return hash[:7]
+	}
+	return hash
+}
+
+// buildDuration returns how long a build took, or has been running for
+func buildDuration(started, finished time.Time) string {
+	if started.IsZero() {
+		return "not started"
+	}
+
+	if finished.IsZero() {
+		return "running for " + time.Since(started).Round(time.Second).String()
+	}
+
+	return "took " + finished.Sub(started).Round(time.Second).String()
+}
+
+// buildState returns the result of a finished build, or its status
+func buildState(status, result string) string {
+	if status == "finished" {
+		return result
+	}
+	return status
+}
+
+// formatBuild describes a build on a single line
+func formatBuild(build wercker.Build) string {
+	author := build.User.Username()
+	if author == "" {
+		author = "unknown"
+	}
+
+	return fmt.Sprintf("*%s* `%s` on %s at `%s` by %s, %s",
+		buildState(build.Status, build.Result), build.ID, build.Branch,
+		shortCommit(build.CommitHash), author, buildDuration(build.StartedAt, build.FinishedAt))
+}
+
+func (s WerckerBuilds) Hear(slack chatAgent, message Message, input string) bool {
+	patter, err := regexp.Compile(`^\bbuilds?\b`)
+
+	if err != nil {
+		taskLog(s, message).error("cannot parse the input", "err", err)
+	}
+
+	if !patter.MatchString(input) {
+		return false
+	}
+
+	options := strings.Fields(input)
+
+	if len(options) == 1 || options[1] == "help" {
+		err := s.Help(slack, message)
+		if err != nil {
+			taskLog(s, message).error("cannot post the help", "err", err)
+			return false
+		}
+		return true
+	}
+
+	if options[0] == "build" {
+		if len(options) != 2 {
+			return false
+		}
+
+		err := s.showBuild(slack, message, options[1])
+		if err != nil {
+			taskLog(s, message).error("cannot show the build", "err", err)
+		}
+		return true
+	}
+
+	args, flags, err := parseFlags(options[1:])
+
+	if err != nil || len(args) != 1 {
+		message.Text = "Usage: @mario builds <app> [--branch <branch>] [--limit <number>]"
+		slack.postMessage(message)
+		return true
+	}
+
+	err = s.listBuilds(slack, message, args[0], flags)
+	if err != nil {
+		taskLog(s, message).error("cannot list the builds", "err", err)
+	}
+	return true
+}
+
+// listBuilds posts the recent builds of an app
+func (s WerckerBuilds) listBuilds(slack chatAgent, message Message, arg string, flags map[string]string) error {
+	org, app := werckerApp(message, arg)
+
+	opts := wercker.BuildOptions{Branch: flags["branch"]}
+	opts.Limit = 5
+
+	if limit, ok := flags["limit"]; ok {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > 50 {
+			message.Text = "The limit must be a number between 1 and 50."
+			return slack.postMessage(message)
+		}
+		opts.Limit = n
+	}
+
+	builds, err := werckerClient(message.Workspace).Builds(org, app, opts)
+
+	if err != nil {
+		message.Text = "Sorry, I couldn't get the builds of " + org + "/" + app + " from Wercker: " + err.Error()
+		slack.postMessage(message)
+		return err
+	}
+
+	if len(builds) == 0 {
+		message.Text = "There are no builds of " + org + "/" + app + " yet."
+		return slack.postMessage(message)
+	}
+
+	message.Text = "Recent builds of " + org + "/" + app + ":\n"
+
+	for _, build := range builds {
+		message.Text += "- " + formatBuild(build) + "\n"
+	}
+
+	return slack.postMessage(message)
+}
+
+// showBuild posts the details of a build, including the steps that failed
+func (s WerckerBuilds) showBuild(slack chatAgent, message Message, id string) error {
+	client := werckerClient(message.Workspace)
+	build, err := client.Build(id)
+
+	if err != nil {
+		message.Text = "Sorry, I couldn't get build " + id + " from Wercker: " + err.Error()
+		slack.postMessage(message)
+		return err
+	}
+
+	steps, err := client.BuildSteps(id)
+
+	if err != nil {
+		message.Text = "Sorry, I couldn't get the steps of build " + id + " from Wercker: " + err.Error()
+		slack.postMessage(message)
+		return err
+	}
+
+	app := "unknown app"
+	if build.Application != nil {
+		app = build.Application.Owner.Username() + "/" + build.Application.Name
+	}
+
+	author := build.User.Username()
+	if author == "" {
+		author = "unknown"
+	}
+
+	message.Text = fmt.Sprintf("Build `%s` of %s is *%s*\n", build.ID, app, buildState(build.Status, build.Result))
+	message.Text += fmt.Sprintf("Branch: %s\n", build.Branch)
+	message.Text += fmt.Sprintf("Commit: `%s` %s\n", shortCommit(build.CommitHash), strings.SplitN(build.Message, "\n", 2)[0])
+	message.Text += fmt.Sprintf("Author: %s\n", author)
+	message.Text += fmt.Sprintf("Created: %s\n", build.CreatedAt.Format("2 Jan 2006 15:04 MST"))
+	message.Text += fmt.Sprintf("Duration: %s\n", buildDuration(build.StartedAt, build.FinishedAt))
+
+	var failed []string
+	for _, step := range steps {
+		if step.Result == "failed" {
+			failed = append(failed, "`"+step.Name+"`")
+		}
+	}
+
+	if len(failed) > 0 {
+		message.Text += "Failed steps: " + strings.Join(failed, ", ") + "\n"
+	}
+
+	return slack.postMessage(message)
+}
+
+func (s WerckerBuilds) Help(slack chatAgent, message Message) error {
+	message.Text = `<builds> lists the recent Wercker builds of an app, <build> shows the details of a build.
+Usage:
+- @mario builds <app> [--branch <branch>] [--limit <number>]
+- @mario builds <organisation>/<app>
+- @mario build <build id>
+`
+
+	err := slack.postMessage(message)
+
+	if err != nil {
+		taskLog(s, message).error("cannot post the help", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s WerckerBuilds) getName() string {
+	return "builds"
+}
@@ -0,0 +1,67 @@
+package wercker
+
+import "time"
+
+// Application is a project on Wercker
+type Application struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Name      string    `json:"name"`
+	Owner     Owner     `json:"owner"`
+	Privacy   string    `json:"privacy"`
+	Stack     int       `json:"stack"`
+	Theme     string    `json:"theme"`
+	BadgeHash string    `json:"badgeHash"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Owner is the user or organisation an application belongs to
+type Owner struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Avatar struct {
+		Gravatar string `json:"gravatar"`
+	} `json:"avatar"`
+	UserID string `json:"userId"`
+	Meta   struct {
+		Username        string `json:"username"`
+		Type            string `json:"type"`
+		WerckerEmployee bool   `json:"werckerEmployee"`
+	} `json:"meta"`
+}
+
+// Username returns the name used in the API paths of the owner's applications
+func (o Owner) Username() string {
+	if o.Meta.Username != "" {
+		return o.Meta.Username
+	}
+	return o.Name
+}
+
+// Applications lists a page of the applications of a user or organisation
+func (c *Client) Applications(owner string, opts ListOptions) ([]Application, error) {
+	var apps []Application
+	err := c.get("applications/"+escape(owner), opts.values(), &apps)
+	return apps, err
+}
+
+// AllApplications lists every application of a user or organisation
+func (c *Client) AllApplications(owner string) ([]Application, error) {
+	var apps []Application
+
+	err := paginate(100, func(opts ListOptions) (int, error) {
+		page, err := c.Applications(owner, opts)
+		apps = append(apps, page...)
+		return len(page), err
+	})
+
+	return apps, err
+}
+
+// Application gets an application of a user or organisation
+func (c *Client) Application(owner, name string) (*Application, error) {
+	app := &Application{}
+	err := c.get("applications/"+escape(owner)+"/"+escape(name), nil, app)
+	return app, err
+}
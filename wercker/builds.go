@@ -0,0 +1,137 @@
+package wercker
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Build is a run of an application's build pipeline
+type Build struct {
+	ID          string       `json:"id"`
+	URL         string       `json:"url"`
+	Application *Application `json:"application,omitempty"`
+	Branch      string       `json:"branch"`
+	CommitHash  string       `json:"commitHash"`
+	Message     string       `json:"message"`
+	// Status is "created", "running" or "finished"
+	Status string `json:"status"`
+	// Result is "passed", "failed", "aborted" or "unknown"
+	Result     string    `json:"result"`
+	Progress   int       `json:"progress"`
+	User       *User     `json:"user,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+}
+
+// User is a Wercker user
+type User struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	UserID string `json:"userId"`
+	Meta   struct {
+		Username string `json:"username"`
+		Type     string `json:"type"`
+	} `json:"meta"`
+}
+
+// Username returns the Wercker username, or the name of the user
+func (u *User) Username() string {
+	if u == nil {
+		return ""
+	}
+	if u.Meta.Username != "" {
+		return u.Meta.Username
+	}
+	return u.Name
+}
+
+// BuildOptions filters the builds of an application
+type BuildOptions struct {
+	ListOptions
+	Branch string
+	Commit string
+	Result string
+	Status string
+}
+
+// Builds lists a page of the builds of an application, most recent first
+func (c *Client) Builds(owner, name string, opts BuildOptions) ([]Build, error) {
+	query := opts.values()
+
+	for key, value := range map[string]string{"branch": opts.Branch, "commit": opts.Commit, "result": opts.Result, "status": opts.Status} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+
+	var builds []Build
+	err := c.get("applications/"+escape(owner)+"/"+escape(name)+"/builds", query, &builds)
+	return builds, err
+}
+
+// Build gets a build
+func (c *Client) Build(id string) (*Build, error) {
+	build := &Build{}
+	err := c.get("builds/"+escape(id), nil, build)
+	return build, err
+}
+
+// Step is a step of a build or of a run
+type Step struct {
+	ID         string    `json:"id"`
+	URL        string    `json:"url"`
+	Name       string    `json:"step"`
+	Status     string    `json:"status"`
+	Result     string    `json:"result"`
+	Order      int       `json:"order"`
+	LogURL     string    `json:"logUrl"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+}
+
+// BuildSteps lists the steps of a build in the order they ran
+func (c *Client) BuildSteps(id string) ([]Step, error) {
+	var steps []Step
+	err := c.get("builds/"+escape(id)+"/steps", nil, &steps)
+	return steps, err
+}
+
+// MaxLogSize is the largest part of a step log that StepLog reads,
+// the end of longer logs is dropped
+const MaxLogSize = 10 << 20
+
+// StepLog gets the log of a step
+// the token is only sent if the log is served by the API,
+// logs are usually stored elsewhere
+func (c *Client) StepLog(step Step) (string, error) {
+	if step.LogURL == "" {
+		return "", fmt.Errorf("wercker: step %q has no log", step.Name)
+	}
+
+	req, err := http.NewRequest("GET", step.LogURL, nil)
+
+	if err != nil {
+		return "", err
+	}
+
+	logs := c
+	if !strings.HasPrefix(step.LogURL, c.url("")) {
+		logs = &Client{BaseURL: c.BaseURL, HTTPClient: c.HTTPClient}
+	}
+
+	res, err := logs.send(req)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, MaxLogSize))
+	return string(body), err
+}
@@ -0,0 +1,179 @@
+// Package wercker is a client for the Wercker v3 API
+// see http://devcenter.wercker.com/docs/api
+package wercker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// DefaultBaseURL is the URL of the public Wercker v3 API
+const DefaultBaseURL = "https://app.wercker.com/api/v3/"
+
+// Client talks to the Wercker API
+type Client struct {
+	// BaseURL of the API, it must end with a slash
+	BaseURL string
+	// Token is a Wercker access token
+	// without a token Wercker only returns public data
+	Token      string
+	HTTPClient *http.Client
+}
+
+// Error is returned when the API answers with an error status
+type Error struct {
+	StatusCode int    `json:"statusCode"`
+	Type       string `json:"error"`
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("wercker: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("wercker: %d %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound returns true if err is a 404 returned by the API
+func IsNotFound(err error) bool {
+	apiErr, ok := err.(*Error)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+// ListOptions selects a page of results
+// the API returns 20 results when Limit is 0
+type ListOptions struct {
+	Limit int
+	Skip  int
+}
+
+func (o ListOptions) values() url.Values {
+	values := url.Values{}
+
+	if o.Limit > 0 {
+		values.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.Skip > 0 {
+		values.Set("skip", strconv.Itoa(o.Skip))
+	}
+
+	return values
+}
+
+// NewClient creates a client for the public Wercker API
+func NewClient(token string) *Client {
+	return &Client{BaseURL: DefaultBaseURL, Token: token, HTTPClient: http.DefaultClient}
+}
+
+// get calls a GET endpoint and decodes the response in v
+func (c *Client) get(path string, query url.Values, v interface{}) error {
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	return c.do("GET", path, nil, v)
+}
+
+func (c *Client) do(method, path string, body interface{}, v interface{}) error {
+	var payload io.Reader
+
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequest(method, c.url(path), payload)
+
+	if err != nil {
+		return err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.send(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if v == nil {
+		return nil
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// send authenticates a request and checks the response status
+// the caller must close the body of the response
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	res, err := httpClient.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		defer res.Body.Close()
+
+		apiErr := &Error{}
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 64*1024))
+		json.Unmarshal(body, apiErr)
+
+		// the status of the response wins over the one in the body
+		apiErr.StatusCode = res.StatusCode
+		return nil, apiErr
+	}
+
+	return res, nil
+}
+
+// url resolves a path against the base URL
+func (c *Client) url(path string) string {
+	base := c.BaseURL
+	if base == "" {
+		base = DefaultBaseURL
+	}
+
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(path, "/")
+}
+
+// paginate calls fetch with increasing skips until it returns a page
+// that isn't full
+func paginate(pageSize int, fetch func(opts ListOptions) (int, error)) error {
+	for skip := 0; ; skip += pageSize {
+		n, err := fetch(ListOptions{Limit: pageSize, Skip: skip})
+
+		if err != nil || n < pageSize {
+			return err
+		}
+	}
+}
+
+// escape escapes a path segment
+func escape(segment string) string {
+	return url.PathEscape(segment)
+}
@@ -0,0 +1,331 @@
+package wercker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newFakeAPI starts a fake of the Wercker v3 API
+// umbrellium owns 250 applications, every other endpoint returns canned data
+// the paths requested are sent to the returned channel
+func newFakeAPI(t *testing.T) (*Client, *httptest.Server, chan string) {
+	requests := make(chan string, 100)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r.URL.RequestURI()
+
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"statusCode":401,"error":"Unauthorized","message":"Invalid token"}`))
+			return
+		}
+
+		write := func(body string) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(body))
+		}
+
+		switch r.URL.Path {
+		case "/api/v3/applications/umbrellium":
+			limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+			skip, _ := strconv.Atoi(r.URL.Query().Get("skip"))
+			if limit == 0 {
+				limit = 20
+			}
+
+			var apps []Application
+			for i := skip; i < 250 && i < skip+limit; i++ {
+				app := Application{ID: strconv.Itoa(i), Name: fmt.Sprintf("app-%d", i), Privacy: "private"}
+				app.Owner.Name = "umbrellium"
+				apps = append(apps, app)
+			}
+			json.NewEncoder(w).Encode(apps)
+
+		case "/api/v3/applications/umbrellium/mario":
+			write(`{"id":"a1","name":"mario","privacy":"public","url":"https://app.wercker.com/api/v3/applications/umbrellium/mario","owner":{"name":"Umbrellium","meta":{"username":"umbrellium"}}}`)
+		case "/api/v3/applications/umbrellium/mario/builds":
+			write(`[{"id":"b1","branch":"master","commitHash":"0123456789abcdef","status":"finished","result":"passed","createdAt":"2016-01-02T10:00:00.000Z"}]`)
+		case "/api/v3/builds/b1":
+			write(`{"id":"b1","branch":"master","status":"running","result":"unknown","progress":40,"application":{"name":"mario"}}`)
+		case "/api/v3/builds/b1/steps":
+			write(`[{"id":"s1","step":"setup environment","status":"finished","result":"passed","order":1},{"id":"s2","step":"go test","status":"finished","result":"failed","order":2,"logUrl":"https://logs.example.com/s2"}]`)
+		case "/api/v3/steps/s1/log":
+			w.Write([]byte("setting up\ndone\n"))
+		case "/api/v3/applications/umbrellium/mario/deploys":
+			write(`[{"id":"d1","status":"finished","result":"failed","build":{"id":"b1","branch":"master"},"deployTarget":{"id":"t2","name":"production"}}]`)
+		case "/api/v3/applications/umbrellium/mario/deploytargets":
+			write(`[{"id":"t1","name":"staging"},{"id":"t2","name":"production"}]`)
+		case "/api/v3/deploys":
+			var req DeployRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			if r.Method != "POST" || req.BuildID != "b1" || req.TargetID != "t2" {
+				w.WriteHeader(http.StatusBadRequest)
+				write(`{"statusCode":400,"message":"Invalid deploy"}`)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			write(`{"id":"d2","status":"created","build":{"id":"b1"}}`)
+		case "/api/v3/deploys/d1":
+			write(`{"id":"d1","status":"finished","result":"passed","user":{"name":"Alice","meta":{"username":"alice"}}}`)
+		case "/api/v3/applications/umbrellium/mario/pipelines":
+			write(`[{"id":"p1","name":"build","pipelineName":"build","type":"git"},{"id":"p2","name":"deploy-production","pipelineName":"deploy","type":"pipeline"}]`)
+		case "/api/v3/pipelines/p1":
+			write(`{"id":"p1","name":"build","pipelineName":"build"}`)
+		case "/api/v3/runs":
+			write(`[{"id":"r1","branch":"master","status":"finished","result":"passed","pipeline":{"id":"p1","name":"build"}}]`)
+		case "/api/v3/runs/r1":
+			write(`{"id":"r1","branch":"feature","status":"running"}`)
+		case "/api/v3/workflows":
+			write(`[{"id":"w1","trigger":"git","data":{"branch":"master","commitHash":"0123456"},"items":[{"id":"i1","status":"finished","result":"passed","data":{"targetName":"build","runId":"r1"}}]}]`)
+		case "/api/v3/workflows/w1":
+			write(`{"id":"w1","trigger":"git","data":{"branch":"master"}}`)
+		case "/api/v3/broken":
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("<html>Bad Gateway</html>"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			write(`{"statusCode":404,"error":"Not Found","message":"Application not found"}`)
+		}
+	})
+
+	server := httptest.NewServer(handler)
+	client := NewClient("secret")
+	client.BaseURL = server.URL + "/api/v3/"
+
+	return client, server, requests
+}
+
+// test that every page of applications is fetched
+func TestAllApplications(t *testing.T) {
+	client, server, requests := newFakeAPI(t)
+	defer server.Close()
+
+	apps, err := client.AllApplications("umbrellium")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(apps) != 250 || apps[249].Name != "app-249" || apps[0].Owner.Name != "umbrellium" {
+		t.Errorf("Expected 250 applications, got %d", len(apps))
+	}
+
+	for _, expected := range []string{
+		"/api/v3/applications/umbrellium?limit=100",
+		"/api/v3/applications/umbrellium?limit=100&skip=100",
+		"/api/v3/applications/umbrellium?limit=100&skip=200",
+	} {
+		if res := <-requests; res != expected {
+			t.Errorf("Expected request %q, got %q", expected, res)
+		}
+	}
+}
+
+// test the query parameters of filtered lists
+func TestListFilters(t *testing.T) {
+	client, server, requests := newFakeAPI(t)
+	defer server.Close()
+
+	type filterTestingStruct struct {
+		call     func() error
+		expected string
+	}
+
+	filterTest := []filterTestingStruct{
+		{func() error {
+			_, err := client.Applications("umbrellium", ListOptions{Limit: 5, Skip: 10})
+			return err
+		}, "/api/v3/applications/umbrellium?limit=5&skip=10"},
+		{func() error {
+			_, err := client.Builds("umbrellium", "mario", BuildOptions{ListOptions: ListOptions{Limit: 3}, Branch: "master", Result: "passed"})
+			return err
+		}, "/api/v3/applications/umbrellium/mario/builds?branch=master&limit=3&result=passed"},
+		{func() error {
+			_, err := client.Deploys("umbrellium", "mario", DeployOptions{Status: "finished"})
+			return err
+		}, "/api/v3/applications/umbrellium/mario/deploys?status=finished"},
+		{func() error {
+			_, err := client.Runs(RunOptions{ApplicationID: "a1", Branch: "master"})
+			return err
+		}, "/api/v3/runs?applicationId=a1&branch=master"},
+		{func() error {
+			_, err := client.Workflows(WorkflowOptions{ApplicationID: "a1", ListOptions: ListOptions{Skip: 20}})
+			return err
+		}, "/api/v3/workflows?applicationId=a1&skip=20"},
+	}
+
+	for _, tst := range filterTest {
+		if err := tst.call(); err != nil {
+			t.Errorf("Expected no error requesting %q, got %v", tst.expected, err)
+		}
+		if res := <-requests; res != tst.expected {
+			t.Errorf("Expected request %q, got %q", tst.expected, res)
+		}
+	}
+}
+
+// test decoding of every model
+func TestModels(t *testing.T) {
+	client, server, _ := newFakeAPI(t)
+	defer server.Close()
+
+	app, err := client.Application("umbrellium", "mario")
+	if err != nil || app.Name != "mario" || app.Privacy != "public" || app.Owner.Username() != "umbrellium" {
+		t.Errorf("Expected the mario application, got %+v, %v", app, err)
+	}
+
+	builds, err := client.Builds("umbrellium", "mario", BuildOptions{})
+	if err != nil || len(builds) != 1 || builds[0].CommitHash != "0123456789abcdef" || builds[0].CreatedAt.Hour() != 10 {
+		t.Errorf("Expected build b1, got %+v, %v", builds, err)
+	}
+
+	build, err := client.Build("b1")
+	if err != nil || build.Progress != 40 || build.Application.Name != "mario" {
+		t.Errorf("Expected a running build, got %+v, %v", build, err)
+	}
+
+	steps, err := client.BuildSteps("b1")
+	if err != nil || len(steps) != 2 || steps[1].Name != "go test" || steps[1].LogURL == "" {
+		t.Errorf("Expected 2 build steps, got %+v, %v", steps, err)
+	}
+
+	log, err := client.StepLog(Step{Name: "setup environment", LogURL: client.BaseURL + "steps/s1/log"})
+	if err != nil || log != "setting up\ndone\n" {
+		t.Errorf("Expected the log of step s1, got %q, %v", log, err)
+	}
+
+	_, err = client.StepLog(steps[0])
+	if err == nil {
+		t.Errorf("Expected a step without log to fail")
+	}
+
+	deploys, err := client.Deploys("umbrellium", "mario", DeployOptions{})
+	if err != nil || len(deploys) != 1 || deploys[0].Result != "failed" || deploys[0].Build.ID != "b1" || deploys[0].Target.Name != "production" {
+		t.Errorf("Expected deploy d1, got %+v, %v", deploys, err)
+	}
+
+	deploy, err := client.Deploy("d1")
+	if err != nil || deploy.User.Username() != "alice" {
+		t.Errorf("Expected a deploy by alice, got %+v, %v", deploy, err)
+	}
+
+	targets, err := client.Targets("umbrellium", "mario")
+	if err != nil || len(targets) != 2 || targets[1].Name != "production" {
+		t.Errorf("Expected 2 deploy targets, got %+v, %v", targets, err)
+	}
+
+	created, err := client.CreateDeploy(DeployRequest{BuildID: "b1", TargetID: "t2"})
+	if err != nil || created.ID != "d2" || created.Status != "created" {
+		t.Errorf("Expected deploy d2 to be created, got %+v, %v", created, err)
+	}
+
+	_, err = client.CreateDeploy(DeployRequest{BuildID: "b1"})
+	if apiErr, ok := err.(*Error); !ok || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected an invalid deploy to fail, got %v", err)
+	}
+
+	pipelines, err := client.Pipelines("umbrellium", "mario")
+	if err != nil || len(pipelines) != 2 || pipelines[1].PipelineName != "deploy" {
+		t.Errorf("Expected 2 pipelines, got %+v, %v", pipelines, err)
+	}
+
+	pipeline, err := client.Pipeline("p1")
+	if err != nil || pipeline.Name != "build" {
+		t.Errorf("Expected the build pipeline, got %+v, %v", pipeline, err)
+	}
+
+	runs, err := client.Runs(RunOptions{ApplicationID: "a1"})
+	if err != nil || len(runs) != 1 || runs[0].Pipeline.ID != "p1" {
+		t.Errorf("Expected run r1, got %+v, %v", runs, err)
+	}
+
+	run, err := client.Run("r1")
+	if err != nil || run.Branch != "feature" {
+		t.Errorf("Expected run r1, got %+v, %v", run, err)
+	}
+
+	workflows, err := client.Workflows(WorkflowOptions{ApplicationID: "a1"})
+	if err != nil || len(workflows) != 1 || workflows[0].Items[0].Data.RunID != "r1" {
+		t.Errorf("Expected workflow w1, got %+v, %v", workflows, err)
+	}
+
+	workflow, err := client.Workflow("w1")
+	if err != nil || workflow.Data.Branch != "master" {
+		t.Errorf("Expected workflow w1, got %+v, %v", workflow, err)
+	}
+}
+
+// test decoding of API errors
+func TestErrors(t *testing.T) {
+	client, server, _ := newFakeAPI(t)
+	defer server.Close()
+
+	_, err := client.Application("umbrellium", "luigi")
+	if !IsNotFound(err) || err.Error() != "wercker: 404 Application not found" {
+		t.Errorf("Expected a not found error, got %v", err)
+	}
+
+	err = client.get("broken", nil, nil)
+	if apiErr, ok := err.(*Error); !ok || apiErr.StatusCode != http.StatusBadGateway {
+		t.Errorf("Expected a bad gateway error, got %v", err)
+	}
+
+	client.Token = "wrong"
+	_, err = client.Build("b1")
+	if apiErr, ok := err.(*Error); !ok || apiErr.StatusCode != http.StatusUnauthorized || apiErr.Message != "Invalid token" {
+		t.Errorf("Expected an unauthorized error, got %v", err)
+	}
+}
+
+// test decoding of webhook payloads
+func TestParseEvent(t *testing.T) {
+	app := `"application":{"name":"mario","owner":{"name":"umbrellium"}}`
+
+	var tests = []struct {
+		payload string
+		valid   bool
+	}{
+		{`{"type":"build",` + app + `,"build":{"id":"b1","status":"finished","result":"passed"}}`, true},
+		{`{"type":"deploy",` + app + `,"deploy":{"id":"d1","status":"running"}}`, true},
+		{`{"type":"deploy",` + app + `,"build":{"id":"b1"}}`, false},
+		{`{"type":"build","build":{"id":"b1"}}`, false},
+		{`{"type":"pipeline",` + app + `}`, false},
+		{`not json`, false},
+	}
+
+	for _, tst := range tests {
+		event, err := ParseEvent(strings.NewReader(tst.payload))
+
+		if tst.valid && (err != nil || event.Application.Owner.Username() != "umbrellium") {
+			t.Errorf("Expected %s to be a valid event, got %+v, %v", tst.payload, event, err)
+		}
+
+		if !tst.valid && err == nil {
+			t.Errorf("Expected %s to be rejected", tst.payload)
+		}
+	}
+}
+
+// test the token isn't sent to the storage of the logs
+func TestStepLogStorage(t *testing.T) {
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	}))
+	defer storage.Close()
+
+	client := NewClient("secret")
+	client.BaseURL = "https://app.wercker.com/api/v3/"
+
+	log, err := client.StepLog(Step{LogURL: storage.URL + "/s2.log"})
+	if err != nil || log != "ok\n" {
+		t.Errorf("Expected the log without the token, got %q, %v", log, err)
+	}
+}
@@ -0,0 +1,76 @@
+package wercker
+
+import "time"
+
+// Deploy is a run of a deploy pipeline to a deploy target
+type Deploy struct {
+	ID         string    `json:"id"`
+	URL        string    `json:"url"`
+	Build      *Build    `json:"build,omitempty"`
+	Target     *Target   `json:"deployTarget,omitempty"`
+	Status     string    `json:"status"`
+	Result     string    `json:"result"`
+	Progress   int       `json:"progress"`
+	User       *User     `json:"user,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+}
+
+// DeployOptions filters the deploys of an application
+type DeployOptions struct {
+	ListOptions
+	Result string
+	Status string
+}
+
+// Deploys lists a page of the deploys of an application, most recent first
+func (c *Client) Deploys(owner, name string, opts DeployOptions) ([]Deploy, error) {
+	query := opts.values()
+
+	if opts.Result != "" {
+		query.Set("result", opts.Result)
+	}
+	if opts.Status != "" {
+		query.Set("status", opts.Status)
+	}
+
+	var deploys []Deploy
+	err := c.get("applications/"+escape(owner)+"/"+escape(name)+"/deploys", query, &deploys)
+	return deploys, err
+}
+
+// Deploy gets a deploy
+func (c *Client) Deploy(id string) (*Deploy, error) {
+	deploy := &Deploy{}
+	err := c.get("deploys/"+escape(id), nil, deploy)
+	return deploy, err
+}
+
+// Target is an environment an application can be deployed to
+type Target struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// DeployRequest is the deploy to create
+type DeployRequest struct {
+	BuildID  string `json:"buildId"`
+	TargetID string `json:"deployTargetId"`
+	Message  string `json:"message,omitempty"`
+}
+
+// Targets lists the deploy targets of an application
+func (c *Client) Targets(owner, name string) ([]Target, error) {
+	var targets []Target
+	err := c.get("applications/"+escape(owner)+"/"+escape(name)+"/deploytargets", nil, &targets)
+	return targets, err
+}
+
+// CreateDeploy deploys a build to a target
+func (c *Client) CreateDeploy(req DeployRequest) (*Deploy, error) {
+	deploy := &Deploy{}
+	err := c.do("POST", "deploys", req, deploy)
+	return deploy, err
+}
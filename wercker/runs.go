@@ -0,0 +1,87 @@
+package wercker
+
+import "time"
+
+// Pipeline is a pipeline defined in an application's wercker.yml
+type Pipeline struct {
+	ID                   string    `json:"id"`
+	URL                  string    `json:"url"`
+	Name                 string    `json:"name"`
+	PipelineName         string    `json:"pipelineName"`
+	Permissions          string    `json:"permissions"`
+	SetScmProviderStatus bool      `json:"setScmProviderStatus"`
+	Type                 string    `json:"type"`
+	CreatedAt            time.Time `json:"createdAt"`
+}
+
+// Run is a run of any pipeline of an application
+type Run struct {
+	ID         string    `json:"id"`
+	URL        string    `json:"url"`
+	Branch     string    `json:"branch"`
+	CommitHash string    `json:"commitHash"`
+	Message    string    `json:"message"`
+	Status     string    `json:"status"`
+	Result     string    `json:"result"`
+	Progress   int       `json:"progress"`
+	Pipeline   *Pipeline `json:"pipeline,omitempty"`
+	User       *User     `json:"user,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+}
+
+// RunOptions filters runs,
+// either ApplicationID or PipelineID must be set
+type RunOptions struct {
+	ListOptions
+	ApplicationID string
+	PipelineID    string
+	Branch        string
+	Commit        string
+	Result        string
+	Status        string
+}
+
+// Pipelines lists the pipelines of an application
+func (c *Client) Pipelines(owner, name string) ([]Pipeline, error) {
+	var pipelines []Pipeline
+	err := c.get("applications/"+escape(owner)+"/"+escape(name)+"/pipelines", nil, &pipelines)
+	return pipelines, err
+}
+
+// Pipeline gets a pipeline
+func (c *Client) Pipeline(id string) (*Pipeline, error) {
+	pipeline := &Pipeline{}
+	err := c.get("pipelines/"+escape(id), nil, pipeline)
+	return pipeline, err
+}
+
+// Runs lists a page of runs, most recent first
+func (c *Client) Runs(opts RunOptions) ([]Run, error) {
+	query := opts.values()
+
+	for key, value := range map[string]string{
+		"applicationId": opts.ApplicationID,
+		"pipelineId":    opts.PipelineID,
+		"branch":        opts.Branch,
+		"commit":        opts.Commit,
+		"result":        opts.Result,
+		"status":        opts.Status,
+	} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+
+	var runs []Run
+	err := c.get("runs", query, &runs)
+	return runs, err
+}
+
+// Run gets a run
+func (c *Client) Run(id string) (*Run, error) {
+	run := &Run{}
+	err := c.get("runs/"+escape(id), nil, run)
+	return run, err
+}
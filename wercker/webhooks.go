@@ -0,0 +1,40 @@
+package wercker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Event is the payload of a Wercker webhook, sent when a build or a deploy
+// of an application starts or finishes
+type Event struct {
+	// Type is "build" or "deploy"
+	Type        string      `json:"type"`
+	Application Application `json:"application"`
+	Build       *Build      `json:"build,omitempty"`
+	Deploy      *Deploy     `json:"deploy,omitempty"`
+}
+
+// ParseEvent decodes a webhook payload
+// Returns an error if the payload isn't a build or deploy event
+func ParseEvent(r io.Reader) (Event, error) {
+	var event Event
+
+	if err := json.NewDecoder(r).Decode(&event); err != nil {
+		return event, fmt.Errorf("wercker: invalid webhook payload: %v", err)
+	}
+
+	if event.Application.Name == "" || event.Application.Owner.Username() == "" {
+		return event, fmt.Errorf("wercker: webhook payload without application")
+	}
+
+	switch {
+	case event.Type == "build" && event.Build != nil:
+	case event.Type == "deploy" && event.Deploy != nil:
+	default:
+		return event, fmt.Errorf("wercker: unknown webhook event %q", event.Type)
+	}
+
+	return event, nil
+}
@@ -0,0 +1,55 @@
+package wercker
+
+import "time"
+
+// Workflow chains the runs of several pipelines triggered by the same event
+type Workflow struct {
+	ID   string `json:"id"`
+	URL  string `json:"url"`
+	Data struct {
+		Branch     string `json:"branch"`
+		CommitHash string `json:"commitHash"`
+		Message    string `json:"message"`
+	} `json:"data"`
+	Items     []WorkflowItem `json:"items"`
+	Trigger   string         `json:"trigger"`
+	CreatedAt time.Time      `json:"createdAt"`
+	UpdatedAt time.Time      `json:"updatedAt"`
+}
+
+// WorkflowItem is a run of a workflow
+type WorkflowItem struct {
+	ID   string `json:"id"`
+	Data struct {
+		TargetName string `json:"targetName"`
+		PipelineID string `json:"pipelineId"`
+		RunID      string `json:"runId"`
+	} `json:"data"`
+	Status   string `json:"status"`
+	Result   string `json:"result"`
+	Progress int    `json:"progress"`
+	Type     string `json:"type"`
+}
+
+// WorkflowOptions filters the workflows of an application
+type WorkflowOptions struct {
+	ListOptions
+	ApplicationID string
+}
+
+// Workflows lists a page of the workflows of an application, most recent first
+func (c *Client) Workflows(opts WorkflowOptions) ([]Workflow, error) {
+	query := opts.values()
+	query.Set("applicationId", opts.ApplicationID)
+
+	var workflows []Workflow
+	err := c.get("workflows", query, &workflows)
+	return workflows, err
+}
+
+// Workflow gets a workflow
+func (c *Client) Workflow(id string) (*Workflow, error) {
+	workflow := &Workflow{}
+	err := c.get("workflows/"+escape(id), nil, workflow)
+	return workflow, err
+}
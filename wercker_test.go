@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/umbrellium/mario/wercker"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// useFakeWercker points the Wercker tasks to a fake of the Wercker API
+// the handler gets every request whose path starts with /api/v3/
+// Returns a function that restores the real client
+func useFakeWercker(t *testing.T, handler http.HandlerFunc) func() {
+	server := httptest.NewServer(http.StripPrefix("/api/v3", handler))
+	client := werckerClient
+
+	werckerClient = func(workspace string) *wercker.Client {
+		c := wercker.NewClient("secret")
+		c.BaseURL = server.URL + "/api/v3/"
+		return c
+	}
+
+	return func() {
+		werckerClient = client
+		server.Close()
+	}
+}
+
+// fakeWerckerBuilds serves the builds of umbrellium/mario
+func fakeWerckerBuilds(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/applications/umbrellium/mario/builds":
+		if r.URL.Query().Get("branch") == "feature" {
+			w.Write([]byte(`[]`))
+			return
+		}
+		w.Write([]byte(`[
+			{"id":"b2","branch":"master","commitHash":"89abcdef0123","status":"running","startedAt":"2016-01-02T10:00:00Z","user":{"meta":{"username":"alice"}}},
+			{"id":"b1","branch":"master","commitHash":"0123456789ab","status":"finished","result":"failed","startedAt":"2016-01-01T10:00:00Z","finishedAt":"2016-01-01T10:02:30Z","user":{"meta":{"username":"bob"}}}
+		]`))
+	case "/builds/b1":
+		w.Write([]byte(`{"id":"b1","branch":"master","commitHash":"0123456789ab","message":"Fix the tests\n\nreally","status":"finished","result":"failed",
+			"createdAt":"2016-01-01T09:59:00Z","startedAt":"2016-01-01T10:00:00Z","finishedAt":"2016-01-01T10:02:30Z",
+			"application":{"name":"mario","owner":{"name":"Umbrellium","meta":{"username":"umbrellium"}}},"user":{"meta":{"username":"bob"}}}`))
+	case "/builds/b1/steps":
+		w.Write([]byte(`[{"step":"setup environment","result":"passed"},{"step":"go build","result":"passed"},{"step":"go test","result":"failed"}]`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"statusCode":404,"message":"Not found"}`))
+	}
+}
+
+// test <builds> and <build> commands
+func TestWerckerBuilds(t *testing.T) {
+	defer useFakeWercker(t, fakeWerckerBuilds)()
+
+	builds := new(WerckerBuilds)
+	var chat FakeSlackChat
+
+	type buildsTestingStruct struct {
+		input    string
+		expected []string
+	}
+
+	buildsTest := []buildsTestingStruct{
+		{"builds mario", []string{"Recent builds of umbrellium/mario", "*running* `b2` on master at `89abcde` by alice, running for", "*failed* `b1` on master at `0123456` by bob, took 2m30s"}},
+		{"builds umbrellium/mario --branch feature", []string{"There are no builds of umbrellium/mario yet."}},
+		{"builds mario --limit 0", []string{"The limit must be a number between 1 and 50."}},
+		{"builds mario --branch", []string{"Usage: @mario builds <app>"}},
+		{"builds luigi", []string{"Sorry, I couldn't get the builds of umbrellium/luigi"}},
+		{"build b1", []string{"Build `b1` of umbrellium/mario is *failed*", "Commit: `0123456` Fix the tests\n", "Author: bob", "Duration: took 2m30s", "Failed steps: `go test`"}},
+		{"build help", []string{"<builds> lists the recent Wercker builds"}},
+	}
+
+	for _, tst := range buildsTest {
+		if !builds.Hear(&chat, msg, tst.input) {
+			t.Fatalf("Expected %q to be handled", tst.input)
+		}
+
+		replies := chat.replies()
+		if len(replies) != 1 {
+			t.Fatalf("Expected %q to post a reply, got %+v", tst.input, replies)
+		}
+
+		for _, expected := range tst.expected {
+			if !strings.Contains(replies[0].Text, expected) {
+				t.Errorf("Expected %q to reply %q, got %q", tst.input, expected, replies[0].Text)
+			}
+		}
+	}
+
+	if builds.Hear(&chat, msg, "buildsmario") || builds.Hear(&chat, msg, "build b1 b2") {
+		t.Errorf("Expected invalid build commands not to be handled")
+	}
+}
+
+// fakeWerckerDeploys serves the builds and deploy targets of umbrellium/mario
+// fakeWerckerLogs serves the builds of fakeWerckerBuilds with the logs of their steps
+func fakeWerckerLogs(w http.ResponseWriter, r *http.Request) {
+	logURL := "http://" + r.Host + "/api/v3/logs/"
+
+	switch r.URL.Path {
+	case "/builds/b1/steps":
+		w.Write([]byte(`[{"step":"setup environment","status":"finished","result":"passed","logUrl":"` + logURL + `setup"},
+			{"step":"go test","status":"finished","result":"failed","logUrl":"` + logURL + `test"},
+			{"step":"store","status":"finished","result":"passed"}]`))
+	case "/logs/setup":
+		for i := 1; i <= 50; i++ {
+			fmt.Fprintf(w, "setup line %d\n", i)
+		}
+	case "/logs/test":
+		for i := 1; i <= 50; i++ {
+			fmt.Fprintf(w, "\x1b[32mok\x1b[0m test %d\n", i)
+		}
+		fmt.Fprintf(w, "--- FAIL: TestMario\n    mario_test.go:12: ```nope```\n")
+		for i := 1; i <= 50; i++ {
+			fmt.Fprintf(w, "after %d\n", i)
+		}
+	default:
+		fakeWerckerBuilds(w, r)
+	}
+}
+
+// test posting the logs of a build step
+func TestWerckerLogs(t *testing.T) {
+	defer useFakeWercker(t, fakeWerckerLogs)()
+
+	logs := new(WerckerLogs)
+	var chat FakeSlackChat
+
+	var tests = []struct {
+		input    string
+		expected []string
+	}{
+		{"logs mario b1", []string{"Step `go test` of build `b1` (failed), lines 41 to 70 of 102:\n```\nok test 41\n", "--- FAIL: TestMario\n    mario_test.go:12: `\u200b``nope`\u200b``\n", "after 18\n```"}},
+		{"logs mario b1 setup environment --lines 5", []string{"lines 46 to 50 of 50:\n```\nsetup line 46\n", "setup line 50\n```"}},
+		{"logs umbrellium/mario b1 deploy", []string{"Build `b1` has no step \"deploy\". Its steps are `setup environment`, `go test`, `store`."}},
+		{"logs mario b1 store", []string{"Sorry, I couldn't get the log of step `store`"}},
+		{"logs luigi b1", []string{"Build `b1` belongs to umbrellium/mario, not umbrellium/luigi."}},
+		{"logs mario b9", []string{"Sorry, I couldn't get build b9"}},
+		{"logs mario b1 --lines 500", []string{"The number of lines must be between 1 and 200."}},
+		{"logs mario", []string{"Usage: @mario logs <app> <build id>"}},
+		{"logs help", []string{"<logs> posts the log of a step"}},
+	}
+
+	for _, tst := range tests {
+		if !logs.Hear(&chat, msg, tst.input) {
+			t.Fatalf("Expected %q to be handled", tst.input)
+		}
+
+		replies := chat.replies()
+		if len(replies) != 1 {
+			t.Fatalf("Expected %q to post a reply, got %+v", tst.input, replies)
+		}
+
+		for _, expected := range tst.expected {
+			if !strings.Contains(replies[0].Text, expected) {
+				t.Errorf("Expected %q to reply %q, got %q", tst.input, expected, replies[0].Text)
+			}
+		}
+	}
+
+	if logs.Hear(&chat, msg, "logsmario") {
+		t.Errorf("Expected an invalid logs command not to be handled")
+	}
+}
+
+// deploy d1 runs for one poll then passes
+func fakeWerckerDeploys() http.HandlerFunc {
+	var mu sync.Mutex
+	polls := 0
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/applications/umbrellium/mario/builds":
+			if r.URL.Query().Get("branch") != "master" || r.URL.Query().Get("result") != "passed" {
+				w.Write([]byte(`[]`))
+				return
+			}
+			w.Write([]byte(`[{"id":"0123456789abcdef01234567","branch":"master","commitHash":"89abcdef0123","message":"Add deploys","status":"finished","result":"passed"}]`))
+		case "/builds/aaaaaaaaaaaaaaaaaaaaaaaa":
+			w.Write([]byte(`{"id":"aaaaaaaaaaaaaaaaaaaaaaaa","status":"running"}`))
+		case "/applications/umbrellium/mario/deploytargets":
+			w.Write([]byte(`[{"id":"t1","name":"staging"},{"id":"t2","name":"production"}]`))
+		case "/deploys":
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id":"d1","status":"created"}`))
+		case "/deploys/d1":
+			mu.Lock()
+			polls++
+			n := polls
+			mu.Unlock()
+
+			if n == 1 {
+				w.Write([]byte(`{"id":"d1","status":"running","startedAt":"2016-01-01T10:00:00Z"}`))
+				return
+			}
+			w.Write([]byte(`{"id":"d1","status":"finished","result":"passed","startedAt":"2016-01-01T10:00:00Z","finishedAt":"2016-01-01T10:01:00Z"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+}
+
+// waitForReplies collects the replies posted by Mario until one contains text
+func waitForReplies(t *testing.T, chat *transcriptChat, text string) []Message {
+	var replies []Message
+	deadline := time.Now().Add(2 * time.Second)
+
+	for time.Now().Before(deadline) {
+		replies = append(replies, chat.replies()...)
+
+		for _, reply := range replies {
+			if strings.Contains(reply.Text, text) {
+				return replies
+			}
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatalf("Expected Mario to reply %q, got %+v", text, replies)
+	return nil
+}
+
+// namedChat is a chat that knows the names of its users, Ualice is alice
+type namedChat struct {
+	*transcriptChat
+}
+
+func (n namedChat) userName(id string) string {
+	return strings.ToLower(strings.TrimPrefix(id, "U"))
+}
+
+// test the <deploy> command from the request to the end of the deploy
+func TestWerckerDeploy(t *testing.T) {
+	defer useBrain(t)()
+
+	var requests []wercker.DeployRequest
+	var mu sync.Mutex
+	fake := fakeWerckerDeploys()
+
+	defer useFakeWercker(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/deploys" {
+			var request wercker.DeployRequest
+			json.NewDecoder(r.Body).Decode(&request)
+
+			mu.Lock()
+			requests = append(requests, request)
+			mu.Unlock()
+		}
+		fake(w, r)
+	})()
+
+	defer func(interval time.Duration) { deployPollInterval = interval }(deployPollInterval)
+	deployPollInterval = time.Millisecond
+
+	os.Setenv("WERCKER_DEPLOYERS", "staging=*,production=Ualice")
+	defer os.Unsetenv("WERCKER_DEPLOYERS")
+
+	chat := &transcriptChat{}
+	say := func(user, text string) []Message {
+		dispatch(namedChat{chat}, Message{Type: "message", Channel: "C1", User: user, Ts: "1.0", Text: "@mario " + text})
+		return chat.replies()
+	}
+
+	type deployTestingStruct struct {
+		user     string
+		input    string
+		expected string
+	}
+
+	deployTest := []deployTestingStruct{
+		{"Ubob", "deploy mario master to production", "Sorry, you are not allowed to deploy to production."},
+		{"Ubob", "deploy mario master production", "Usage: @mario deploy"},
+		{"Ubob", "deploy mario master to qa", "Sorry, you are not allowed to deploy to qa."},
+		{"Ubob", "deploy mario feature to staging", "there is no passing build of branch feature"},
+		{"Ubob", "deploy mario aaaaaaaaaaaaaaaaaaaaaaaa to staging", "build aaaaaaaaaaaaaaaaaaaaaaaa is running"},
+		// only the person who asked can confirm
+		{"Ualice", "deploy mario master to production", "Deploy build `0123456789abcdef01234567` of umbrellium/mario (master at `89abcde`: Add deploys) to *production*?"},
+		{"Ubob", "yes", "I don't understand"},
+		{"Ualice", "no", "OK, I won't deploy umbrellium/mario."},
+		{"Ualice", "yes", "I don't understand"},
+	}
+
+	for _, tst := range deployTest {
+		replies := say(tst.user, tst.input)
+
+		if len(replies) != 1 || !strings.Contains(replies[0].Text, tst.expected) {
+			t.Fatalf("Expected %s's %q to reply %q, got %+v", tst.user, tst.input, tst.expected, replies)
+		}
+	}
+
+	say("Ualice", "deploy mario master to production")
+	say("Ualice", "yes")
+
+	replies := waitForReplies(t, chat, "Deploy `d1` of umbrellium/mario to *production* *passed*, took 1m0s.")
+
+	expected := []string{
+		"Deploying build `0123456789abcdef01234567` of umbrellium/mario to *production*, deploy `d1`.",
+		"Deploy `d1` is running.",
+		"Deploy `d1` of umbrellium/mario to *production* *passed*, took 1m0s.",
+	}
+
+	if len(replies) != len(expected) {
+		t.Fatalf("Expected %d replies, got %+v", len(expected), replies)
+	}
+
+	for i, reply := range replies {
+		if reply.Text != expected[i] || reply.ThreadTs != "1.0" {
+			t.Errorf("Expected %q in thread 1.0, got %q in thread %q", expected[i], reply.Text, reply.ThreadTs)
+		}
+	}
+
+	mu.Lock()
+	if len(requests) != 1 || requests[0].Message != "Deployed from chat by alice" {
+		t.Errorf("Expected Wercker to be told who deployed, got %+v", requests)
+	}
+	mu.Unlock()
+
+	// the audit log tells how each deploy command ended
+	records, err := audit.query(auditFilter{Task: "deploy"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var outcomes []string
+	for _, r := range records {
+		outcomes = append(outcomes, r.Outcome)
+	}
+
+	if fmt.Sprint(outcomes) != "[refused handled refused failed failed cancelled deployed]" {
+		t.Errorf("Expected the outcome of every deploy, got %v", outcomes)
+	}
+
+	deploys, err := deployAudit.deploys("", "umbrellium/mario")
+
+	if err != nil || deploys["d1"].Command != records[len(records)-1].ID {
+		t.Errorf("Expected the deploy record to link to its command, got %+v, %v", deploys, err)
+	}
+}